@@ -0,0 +1,168 @@
+package main
+
+import (
+	"awesomeProject/archive"
+	"awesomeProject/db"
+	"awesomeProject/parser"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/textproto"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	archiveStore     archive.Store
+	archiveStoreOnce sync.Once
+)
+
+// getArchiveStore 返回进程内共享的归档存储，ARCHIVE_STORE为空或存储不可用时返回nil
+func getArchiveStore() archive.Store {
+	archiveStoreOnce.Do(func() {
+		kind := os.Getenv("ARCHIVE_STORE")
+		if kind == "" {
+			return
+		}
+		store, err := archive.NewStore(kind)
+		if err != nil {
+			logs.Warn("页面归档不可用:", err)
+			return
+		}
+		archiveStore = store
+	})
+	return archiveStore
+}
+
+// TaskArchive 将一个关键词(或ASIN)抓取到的原始页面写入WARC文件，
+// Close时上传到归档存储并记录到task_archives表。未启用归档时为nil，方法可以直接调用
+type TaskArchive struct {
+	task  *Task
+	store archive.Store
+
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	w     *archive.Writer
+	pages int
+}
+
+// newTaskArchive 为任务创建归档，未启用归档时返回nil
+func newTaskArchive(task *Task) *TaskArchive {
+	store := getArchiveStore()
+	if store == nil {
+		return nil
+	}
+	a := &TaskArchive{task: task, store: store}
+	a.w = archive.NewWriter(&a.buf)
+	header := a.header(0)
+	header.Set("Content-Type", "application/warc-fields")
+	info := fmt.Sprintf("software: awz_scrapy\r\nformat: WARC File Format 1.1\r\nparser-version: %s\r\n", parser.Version)
+	if err := a.w.WriteRecord(&archive.Record{
+		Type:   archive.TypeWarcinfo,
+		Header: header,
+		Block:  []byte(info),
+	}); err != nil {
+		logs.Warn("写入归档失败:", err)
+		return nil
+	}
+	return a
+}
+
+// Page 归档一个页面的响应
+func (a *TaskArchive) Page(page int, rawURL string, resp *resty.Response) {
+	if a == nil || resp == nil || resp.RawResponse == nil {
+		return
+	}
+	header := a.header(page)
+	header.Set("WARC-Target-URI", rawURL)
+	header.Set("Content-Type", "application/http;msgtype=response")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.w.WriteRecord(&archive.Record{
+		Type:   archive.TypeResponse,
+		Header: header,
+		Block:  archive.EncodeHTTPResponse(resp.StatusCode(), resp.Header(), resp.Body()),
+	})
+	if err != nil {
+		logs.Warn("写入归档失败:", err)
+		return
+	}
+	a.pages++
+}
+
+// Close 保存归档文件并记录到数据库
+func (a *TaskArchive) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pages == 0 {
+		return
+	}
+	uri, err := a.store.Put(a.name(), a.buf.Bytes())
+	if err != nil {
+		logs.Err("保存页面归档失败:", err)
+		return
+	}
+	postgresDB, err := db.NewPostgresDB()
+	if err != nil {
+		logs.Err("记录页面归档失败:", err)
+		return
+	}
+	defer postgresDB.Close()
+	err = postgresDB.SaveTaskArchive(db.TaskArchive{
+		TaskID:        a.task.TaskID,
+		TaskType:      a.task.TaskType,
+		Keyword:       a.subject(),
+		URI:           uri,
+		Pages:         a.pages,
+		ParserVersion: parser.Version,
+	})
+	if err != nil {
+		logs.Err("记录页面归档失败:", err)
+		return
+	}
+	logs.Info(fmt.Sprintf("已归档%d个页面: %s", a.pages, uri))
+}
+
+// header 返回记录页面所属任务的WARC头
+func (a *TaskArchive) header(page int) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set(archive.HeaderTaskID, a.task.TaskID)
+	header.Set(archive.HeaderTaskType, a.task.TaskType)
+	header.Set(archive.HeaderKeyword, a.task.Keyword)
+	header.Set(archive.HeaderASIN, a.task.ASIN)
+	header.Set(archive.HeaderCode, a.task.Code)
+	header.Set(archive.HeaderZipCode, a.task.ZipCode)
+	header.Set(archive.HeaderParserVersion, parser.Version)
+	if page > 0 {
+		header.Set(archive.HeaderPage, strconv.Itoa(page))
+	}
+	return header
+}
+
+// subject 返回归档对应的关键词，ASIN任务返回关键词和ASIN
+func (a *TaskArchive) subject() string {
+	if a.task.ASIN == "" {
+		return a.task.Keyword
+	}
+	if a.task.Keyword == "" {
+		return a.task.ASIN
+	}
+	return a.task.Keyword + "|" + a.task.ASIN
+}
+
+// name 返回归档文件名: <任务ID>/<任务类型>-<关键词摘要>-<时间>.warc.gz
+func (a *TaskArchive) name() string {
+	sum := sha256.Sum256([]byte(a.subject()))
+	return fmt.Sprintf("%s/%s-%s-%s.warc.gz", a.task.TaskID, a.task.TaskType,
+		hex.EncodeToString(sum[:4]), time.Now().UTC().Format("20060102T150405Z"))
+}
@@ -0,0 +1,186 @@
+// Package archive 将抓取到的原始响应写入WARC文件，并支持从WARC文件中读回，
+// 解析器出错时可以用归档重新解析历史任务
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WARC记录类型
+const (
+	TypeWarcinfo = "warcinfo"
+	TypeResponse = "response"
+)
+
+// 自定义的WARC头，记录页面所属的任务
+const (
+	HeaderTaskID        = "X-Task-ID"
+	HeaderTaskType      = "X-Task-Type"
+	HeaderKeyword       = "X-Keyword"
+	HeaderASIN          = "X-ASIN"
+	HeaderCode          = "X-Code"
+	HeaderZipCode       = "X-Zip-Code"
+	HeaderPage          = "X-Page"
+	HeaderParserVersion = "X-Parser-Version"
+)
+
+// Record 表示一条WARC记录，Header中不需要包含WARC-Record-ID、WARC-Date和Content-Length，写入时自动生成
+type Record struct {
+	Type   string
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+// Get 返回记录头的值
+func (r *Record) Get(key string) string {
+	return r.Header.Get(key)
+}
+
+// Writer 写入gzip压缩的WARC文件，每条记录单独压缩为一个gzip成员，符合.warc.gz的惯例
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter 创建WARC写入器
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteRecord 写入一条记录
+func (w *Writer) WriteRecord(record *Record) error {
+	var buf bytes.Buffer
+	buf.WriteString("WARC/1.1\r\n")
+	writeHeader(&buf, "WARC-Type", record.Type)
+	writeHeader(&buf, "WARC-Record-ID", NewRecordID())
+	writeHeader(&buf, "WARC-Date", time.Now().UTC().Format(time.RFC3339))
+	keys := make([]string, 0, len(record.Header))
+	for key := range record.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range record.Header[key] {
+			writeHeader(&buf, key, value)
+		}
+	}
+	writeHeader(&buf, "Content-Length", strconv.Itoa(len(record.Block)))
+	buf.WriteString("\r\n")
+	buf.Write(record.Block)
+	buf.WriteString("\r\n\r\n")
+
+	gz := gzip.NewWriter(w.w)
+	if _, err := gz.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeHeader(buf *bytes.Buffer, key string, value string) {
+	// 头的值不能包含换行
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+// Reader 读取gzip压缩的WARC文件
+type Reader struct {
+	r  *bufio.Reader
+	gz *gzip.Reader
+}
+
+// NewReader 创建WARC读取器
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadRecord 读取下一条记录，没有更多记录时返回io.EOF
+func (r *Reader) ReadRecord() (*Record, error) {
+	if r.gz == nil {
+		gz, err := gzip.NewReader(r.r)
+		if err != nil {
+			return nil, err
+		}
+		r.gz = gz
+	} else if err := r.gz.Reset(r.r); err != nil {
+		return nil, err
+	}
+	r.gz.Multistream(false)
+
+	tp := textproto.NewReader(bufio.NewReader(r.gz))
+	version, err := tp.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("读取WARC记录失败: %v", err)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("不是WARC记录: %q", version)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("读取WARC记录头失败: %v", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("WARC记录长度错误: %v", err)
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(tp.R, block); err != nil {
+		return nil, fmt.Errorf("读取WARC记录内容失败: %v", err)
+	}
+	// 丢弃记录末尾的换行，读到成员结尾以便读取下一个gzip成员
+	if _, err := io.Copy(io.Discard, tp.R); err != nil {
+		return nil, err
+	}
+	return &Record{Type: header.Get("WARC-Type"), Header: header, Block: block}, nil
+}
+
+// NewRecordID 生成WARC-Record-ID
+func NewRecordID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// EncodeHTTPResponse 将HTTP响应编码为response记录的内容。body已经解压，
+// 因此去掉Content-Encoding并按实际长度重写Content-Length
+func EncodeHTTPResponse(statusCode int, header http.Header, body []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode))
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	_ = header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// ParseHTTPResponse 解析response记录中的HTTP响应，返回状态码、响应头和响应体
+func ParseHTTPResponse(block []byte) (int, http.Header, []byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("解析HTTP响应失败: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, nil, nil, fmt.Errorf("读取HTTP响应体失败: %v", err)
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
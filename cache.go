@@ -0,0 +1,295 @@
+package main
+
+import (
+	"awesomeProject/db"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/go-resty/resty/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// 响应缓存模式
+const (
+	// CacheOff 不使用缓存
+	CacheOff = ""
+	// CacheReadThrough 优先读取缓存，未命中时请求并写入缓存
+	CacheReadThrough = "read-through"
+	// CacheRefresh 总是请求并覆盖缓存
+	CacheRefresh = "refresh"
+	// CacheOnly 只读取缓存，不发起请求，用于离线调试解析器
+	CacheOnly = "cache-only"
+)
+
+// ErrCacheMiss 表示cache-only模式下缓存中没有该页面
+var ErrCacheMiss = errors.New("缓存未命中")
+
+// CachedResponse 表示缓存的页面响应
+type CachedResponse struct {
+	URL        string      `json:"url"`
+	Code       string      `json:"code"`
+	ZipCode    string      `json:"zip_code"`
+	Profile    string      `json:"profile"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body,omitempty"`
+	FetchedAt  time.Time   `json:"fetched_at"`
+}
+
+// CacheStore 缓存的存储
+type CacheStore interface {
+	// Get 读取缓存，不存在时返回nil
+	Get(key string) (*CachedResponse, error)
+	// Put 写入缓存，ttl为缓存的有效期
+	Put(key string, resp *CachedResponse, ttl time.Duration) error
+}
+
+// ResponseCache 按URL、站点、邮编和请求头Profile缓存页面响应
+type ResponseCache struct {
+	Mode  string
+	store CacheStore
+	ttl   time.Duration
+}
+
+var (
+	responseCache     *ResponseCache
+	responseCacheOnce sync.Once
+)
+
+// getResponseCache 返回进程内共享的响应缓存，未启用或存储不可用时返回nil
+func getResponseCache() *ResponseCache {
+	responseCacheOnce.Do(func() {
+		mode := os.Getenv("CACHE_MODE")
+		if mode == CacheOff {
+			return
+		}
+		if mode != CacheReadThrough && mode != CacheRefresh && mode != CacheOnly {
+			logs.Warn("不支持的缓存模式:", mode)
+			return
+		}
+		store, err := NewCacheStore(os.Getenv("CACHE_STORE"))
+		if err != nil {
+			logs.Warn("响应缓存不可用:", err)
+			return
+		}
+		responseCache = &ResponseCache{
+			Mode:  mode,
+			store: store,
+			ttl:   envDuration("CACHE_TTL", 24*time.Hour),
+		}
+		logs.Info(fmt.Sprintf("响应缓存模式: %s", mode))
+	})
+	return responseCache
+}
+
+// NewCacheStore 根据类型创建缓存存储，类型为空时使用磁盘，目录来自CACHE_DIR
+func NewCacheStore(kind string) (CacheStore, error) {
+	switch kind {
+	case "", "disk":
+		dir := os.Getenv("CACHE_DIR")
+		if dir == "" {
+			dir = "cache"
+		}
+		return &DiskCacheStore{dir: dir}, nil
+	case "redis":
+		postgresDB, err := db.NewPostgresDB()
+		if err != nil {
+			return nil, fmt.Errorf("创建数据库连接失败: %v", err)
+		}
+		defer postgresDB.Close()
+		client, err := postgresDB.NewRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return &RedisCacheStore{client: client, prefix: "amazon:http_cache:"}, nil
+	default:
+		return nil, fmt.Errorf("不支持的缓存存储: %s", kind)
+	}
+}
+
+// Lookup 按当前模式读取缓存，返回nil表示需要发起请求
+func (c *ResponseCache) Lookup(key string) *CachedResponse {
+	if c.Mode == CacheRefresh {
+		return nil
+	}
+	cached, err := c.store.Get(key)
+	if err != nil {
+		logs.Warn("读取响应缓存失败:", err)
+		return nil
+	}
+	if cached == nil {
+		return nil
+	}
+	// cache-only模式下过期的缓存也可以使用
+	if c.Mode != CacheOnly && time.Since(cached.FetchedAt) > c.ttl {
+		return nil
+	}
+	return cached
+}
+
+// Store 写入缓存
+func (c *ResponseCache) Store(key string, cached *CachedResponse) {
+	if c.Mode == CacheOnly {
+		return
+	}
+	if err := c.store.Put(key, cached, c.ttl); err != nil {
+		logs.Warn("写入响应缓存失败:", err)
+	}
+}
+
+// cacheKey 返回缓存键，同一URL在不同站点、邮编和请求头Profile下的内容可能不同
+func cacheKey(rawURL string, code string, zipCode string, profile string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{rawURL, code, zipCode, profile}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedGet 按缓存模式读取页面，命中时返回由缓存构造的响应，
+// cache-only模式下未命中时返回ErrCacheMiss，其他情况返回nil表示需要发起请求
+func (f *Fetcher) cachedGet(rawURL string) (*resty.Response, []Attempt, error) {
+	cache := getResponseCache()
+	if cache == nil {
+		return nil, nil, nil
+	}
+	cached := cache.Lookup(cacheKey(rawURL, f.country, f.zipCode, f.profile.Name))
+	if cached == nil {
+		if cache.Mode == CacheOnly {
+			return nil, nil, fmt.Errorf("%w: %s", ErrCacheMiss, rawURL)
+		}
+		return nil, nil, nil
+	}
+
+	req := f.Client.R()
+	req.Method = http.MethodGet
+	req.URL = rawURL
+	resp := &resty.Response{
+		Request: req,
+		RawResponse: &http.Response{
+			Status:     fmt.Sprintf("%d %s", cached.StatusCode, http.StatusText(cached.StatusCode)),
+			StatusCode: cached.StatusCode,
+			Header:     cached.Header,
+		},
+	}
+	resp.SetBody(cached.Body)
+	attempt := Attempt{Node: "cache", StatusCode: cached.StatusCode, Time: cached.FetchedAt}
+	return resp, []Attempt{attempt}, nil
+}
+
+// storeResponse 将成功的页面响应写入缓存
+func (f *Fetcher) storeResponse(rawURL string, resp *resty.Response) {
+	cache := getResponseCache()
+	if cache == nil || resp == nil || resp.StatusCode() != http.StatusOK {
+		return
+	}
+	cache.Store(cacheKey(rawURL, f.country, f.zipCode, f.profile.Name), &CachedResponse{
+		URL:        rawURL,
+		Code:       f.country,
+		ZipCode:    f.zipCode,
+		Profile:    f.profile.Name,
+		StatusCode: resp.StatusCode(),
+		Header:     resp.Header(),
+		Body:       resp.Body(),
+		FetchedAt:  time.Now(),
+	})
+}
+
+// DiskCacheStore 将缓存保存在磁盘上，页面保存为<站点>/<键>.html，元数据保存为同名的.json，
+// HTML文件可以直接用parse子命令解析
+type DiskCacheStore struct {
+	dir string
+}
+
+func (s *DiskCacheStore) Get(key string) (*CachedResponse, error) {
+	htmlPath, metaPath, err := s.find(key)
+	if err != nil || htmlPath == "" {
+		return nil, err
+	}
+	meta, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	cached := &CachedResponse{}
+	if err := json.Unmarshal(meta, cached); err != nil {
+		return nil, err
+	}
+	if cached.Body, err = os.ReadFile(htmlPath); err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+// Put 写入缓存，过期时间由元数据中的抓取时间判断
+func (s *DiskCacheStore) Put(key string, resp *CachedResponse, _ time.Duration) error {
+	dir := filepath.Join(s.dir, resp.Code)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	meta := *resp
+	meta.Body = nil
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, key+".html"), resp.Body); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, key+".json"), data)
+}
+
+// find 在各站点目录中查找缓存文件
+func (s *DiskCacheStore) find(key string) (string, string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*", key+".json"))
+	if err != nil || len(matches) == 0 {
+		return "", "", err
+	}
+	metaPath := matches[0]
+	return strings.TrimSuffix(metaPath, ".json") + ".html", metaPath, nil
+}
+
+// writeFileAtomic 先写入临时文件再重命名，避免并发读取到不完整的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RedisCacheStore 将缓存保存在Redis中，过期后自动删除
+type RedisCacheStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *RedisCacheStore) Get(key string) (*CachedResponse, error) {
+	data, err := s.client.Get(context.Background(), s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := &CachedResponse{}
+	if err := json.Unmarshal(data, cached); err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func (s *RedisCacheStore) Put(key string, resp *CachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), s.prefix+key, data, ttl).Err()
+}
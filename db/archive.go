@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TaskArchive 表示task_archives表中的一个WARC归档文件
+type TaskArchive struct {
+	TaskID        string
+	TaskType      string
+	Keyword       string
+	URI           string
+	Pages         int
+	ParserVersion string
+	CreatedAt     time.Time
+}
+
+// SaveTaskArchive 记录任务的归档文件
+func (db *PostgresDB) SaveTaskArchive(archive TaskArchive) error {
+	query := `INSERT INTO task_archives (task_id, task_type, keyword, uri, pages, parser_version)
+	         VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := db.pool.Exec(context.Background(), query, archive.TaskID, archive.TaskType,
+		archive.Keyword, archive.URI, archive.Pages, archive.ParserVersion)
+	if err != nil {
+		return fmt.Errorf("保存任务归档记录失败: %v", err)
+	}
+	return nil
+}
+
+// ListTaskArchives 查询任务的全部归档文件，按创建时间排序
+func (db *PostgresDB) ListTaskArchives(taskID string) ([]TaskArchive, error) {
+	query := `SELECT task_id, task_type, keyword, uri, pages, parser_version, created_at
+	         FROM task_archives WHERE task_id = $1 ORDER BY created_at, id`
+	rows, err := db.pool.Query(context.Background(), query, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("查询任务归档记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	archives := []TaskArchive{}
+	for rows.Next() {
+		var a TaskArchive
+		if err := rows.Scan(&a.TaskID, &a.TaskType, &a.Keyword, &a.URI, &a.Pages, &a.ParserVersion, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("读取任务归档记录失败: %v", err)
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/jackc/pgx/v4"
+)
+
+// 任务状态，租约相关的字段见migrations/004_task_leasing.sql
+const (
+	TaskStatusPending = "待执行"
+	TaskStatusRunning = "执行中"
+)
+
+// TaskNotifyChannel 新任务插入时触发器发送通知的频道
+const TaskNotifyChannel = "keywords_scrapy_task"
+
+// LeasedTask 表示worker领取到的任务
+type LeasedTask struct {
+	TaskID      string
+	CountryCode string
+	Attempts    int
+	MaxAttempts int
+}
+
+// LeaseTask 领取一个待执行的任务并设置为执行中，租约在ttl后过期。codes不为空时只领取这些国家的任务，
+// 没有可领取的任务时返回nil
+func (db *PostgresDB) LeaseTask(owner string, ttl time.Duration, codes []string) (*LeasedTask, error) {
+	if codes == nil {
+		codes = []string{}
+	}
+	query := `UPDATE keywords_scrapy_task
+	         SET status = '执行中', lease_owner = $1, lease_expires_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second',
+	             heartbeat_at = CURRENT_TIMESTAMP, attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+	         WHERE task_id = (
+	             SELECT task_id FROM keywords_scrapy_task
+	             WHERE status = '待执行' AND attempts < max_attempts
+	               AND (cardinality($3::text[]) = 0 OR country_code = ANY($3::text[]))
+	             ORDER BY updated_at
+	             LIMIT 1
+	             FOR UPDATE SKIP LOCKED)
+	         RETURNING task_id, country_code, attempts, max_attempts`
+	var task LeasedTask
+	err := db.pool.QueryRow(context.Background(), query, owner, ttl.Seconds(), codes).Scan(
+		&task.TaskID, &task.CountryCode, &task.Attempts, &task.MaxAttempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("领取任务失败: %v", err)
+	}
+	return &task, nil
+}
+
+// HeartbeatTask 续期任务租约，返回false表示租约已经不属于owner(过期后被回收)
+func (db *PostgresDB) HeartbeatTask(taskID string, owner string, ttl time.Duration) (bool, error) {
+	query := `UPDATE keywords_scrapy_task
+	         SET heartbeat_at = CURRENT_TIMESTAMP, lease_expires_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+	         WHERE task_id = $1 AND lease_owner = $2 AND status = '执行中'`
+	tag, err := db.pool.Exec(context.Background(), query, taskID, owner, ttl.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("续期任务租约失败: %v", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ReleaseTask 任务结束并更新状态后释放租约，状态仍为执行中(例如任务信息查询失败)时标记为已失败
+func (db *PostgresDB) ReleaseTask(taskID string, owner string) error {
+	query := `UPDATE keywords_scrapy_task
+	         SET status = CASE WHEN status = '执行中' THEN '已失败' ELSE status END,
+	             lease_owner = NULL, lease_expires_at = NULL
+	         WHERE task_id = $1 AND lease_owner = $2`
+	_, err := db.pool.Exec(context.Background(), query, taskID, owner)
+	if err != nil {
+		return fmt.Errorf("释放任务租约失败: %v", err)
+	}
+	return nil
+}
+
+// RequeueTask 任务未能执行完(例如panic)时放回待执行，执行次数达到max_attempts时标记为已失败
+func (db *PostgresDB) RequeueTask(taskID string, owner string, reason string) error {
+	query := `UPDATE keywords_scrapy_task
+	         SET status = CASE WHEN attempts >= max_attempts THEN '已失败' ELSE '待执行' END,
+	             err_msg = $3, lease_owner = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+	         WHERE task_id = $1 AND lease_owner = $2 AND status = '执行中'`
+	_, err := db.pool.Exec(context.Background(), query, taskID, owner, reason)
+	if err != nil {
+		return fmt.Errorf("任务放回待执行失败: %v", err)
+	}
+	return nil
+}
+
+// ReturnTask worker退出时把没有执行完的任务放回待执行，本次执行不计入执行次数
+func (db *PostgresDB) ReturnTask(taskID string, owner string, reason string) error {
+	query := `UPDATE keywords_scrapy_task
+	         SET status = '待执行', attempts = GREATEST(attempts - 1, 0),
+	             err_msg = $3, lease_owner = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+	         WHERE task_id = $1 AND lease_owner = $2 AND status = '执行中'`
+	_, err := db.pool.Exec(context.Background(), query, taskID, owner, reason)
+	if err != nil {
+		return fmt.Errorf("任务放回待执行失败: %v", err)
+	}
+	return nil
+}
+
+// ReclaimExpiredTasks 回收租约已过期的任务(worker崩溃或失联)，放回待执行，
+// 执行次数达到max_attempts时标记为已失败，返回回收的任务数
+func (db *PostgresDB) ReclaimExpiredTasks() (int64, error) {
+	query := `UPDATE keywords_scrapy_task
+	         SET status = CASE WHEN attempts >= max_attempts THEN '已失败' ELSE '待执行' END,
+	             err_msg = CASE WHEN attempts >= max_attempts
+	                 THEN 'worker ' || COALESCE(lease_owner, '') || ' 的租约过期，已达到最大执行次数'
+	                 ELSE err_msg END,
+	             lease_owner = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+	         WHERE status = '执行中' AND lease_expires_at < CURRENT_TIMESTAMP`
+	tag, err := db.pool.Exec(context.Background(), query)
+	if err != nil {
+		return 0, fmt.Errorf("回收过期任务失败: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListenTasks 监听新任务通知，收到通知时向返回的通道发送国家代码。
+// 使用连接池中的一个专用连接，断开后自动重连，ctx取消后关闭通道
+func (db *PostgresDB) ListenTasks(ctx context.Context) <-chan string {
+	notifications := make(chan string, 1)
+	go func() {
+		defer close(notifications)
+		for ctx.Err() == nil {
+			if err := db.listen(ctx, notifications); err != nil && ctx.Err() == nil {
+				logs.Warn("监听任务通知失败，稍后重连:", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}
+	}()
+	return notifications
+}
+
+func (db *PostgresDB) listen(ctx context.Context, notifications chan<- string) error {
+	pooled, err := db.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// LISTEN状态跟随连接，连接不再放回连接池
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+TaskNotifyChannel); err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		select {
+		case notifications <- notification.Payload:
+		default:
+			// 已经有未处理的通知，空闲的worker会被唤醒
+		}
+	}
+}
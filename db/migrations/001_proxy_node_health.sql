@@ -0,0 +1,6 @@
+-- 代理节点健康评分，由proxy.HealthService定期写入，重启后加载
+CREATE TABLE IF NOT EXISTS proxy_node_health (
+    node       TEXT PRIMARY KEY,
+    data       JSONB       NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+-- 任务使用的代理流量，proxy_traffic为按节点统计的明细
+ALTER TABLE keywords_scrapy_task
+    ADD COLUMN IF NOT EXISTS proxy_requests BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS proxy_bytes    BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS proxy_blocked  BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS proxy_retries  BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS proxy_cost     NUMERIC(12, 4) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS proxy_traffic  JSONB;
@@ -0,0 +1,13 @@
+-- 任务抓取页面的WARC归档，每个关键词一个文件，reparse命令据此重新解析历史任务
+CREATE TABLE IF NOT EXISTS task_archives (
+    id             BIGSERIAL PRIMARY KEY,
+    task_id        TEXT        NOT NULL,
+    task_type      TEXT        NOT NULL,
+    keyword        TEXT        NOT NULL DEFAULT '',
+    uri            TEXT        NOT NULL,
+    pages          INTEGER     NOT NULL DEFAULT 0,
+    parser_version TEXT        NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS task_archives_task_id_idx ON task_archives (task_id, created_at);
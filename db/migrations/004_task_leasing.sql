@@ -0,0 +1,28 @@
+-- 任务租约: worker用SELECT ... FOR UPDATE SKIP LOCKED领取待执行的任务，
+-- 状态流转为 待执行 -> 执行中 -> 已完成/已失败/已中断，租约过期后任务重新变为待执行
+ALTER TABLE keywords_scrapy_task
+    ADD COLUMN IF NOT EXISTS lease_owner      TEXT,
+    ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS heartbeat_at     TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS attempts         INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_attempts     INTEGER NOT NULL DEFAULT 3;
+
+CREATE INDEX IF NOT EXISTS keywords_scrapy_task_pending_idx
+    ON keywords_scrapy_task (updated_at) WHERE status = '待执行';
+CREATE INDEX IF NOT EXISTS keywords_scrapy_task_lease_idx
+    ON keywords_scrapy_task (lease_expires_at) WHERE status = '执行中';
+
+-- 新任务插入或任务重新变为待执行时通知空闲的worker，payload为国家代码
+CREATE OR REPLACE FUNCTION notify_keywords_scrapy_task() RETURNS trigger AS $$
+BEGIN
+    IF NEW.status = '待执行' AND (TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM NEW.status) THEN
+        PERFORM pg_notify('keywords_scrapy_task', COALESCE(NEW.country_code, ''));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS keywords_scrapy_task_notify ON keywords_scrapy_task;
+CREATE TRIGGER keywords_scrapy_task_notify
+    AFTER INSERT OR UPDATE OF status ON keywords_scrapy_task
+    FOR EACH ROW EXECUTE FUNCTION notify_keywords_scrapy_task();
@@ -0,0 +1,18 @@
+-- 任务中每个关键词的执行情况，父任务keywords_scrapy_task根据子任务汇总状态，
+-- 部分关键词失败时父任务状态为'部分完成'
+CREATE TABLE IF NOT EXISTS keywords_scrapy_subtask (
+    id           BIGSERIAL PRIMARY KEY,
+    task_id      TEXT        NOT NULL,
+    keyword      TEXT        NOT NULL,
+    status       TEXT        NOT NULL,
+    pages        INTEGER     NOT NULL DEFAULT 0,
+    failed_pages INTEGER     NOT NULL DEFAULT 0,
+    products     INTEGER     NOT NULL DEFAULT 0,
+    asin_num     INTEGER     NOT NULL DEFAULT 0,
+    error_class  TEXT        NOT NULL DEFAULT '',
+    err_msg      TEXT        NOT NULL DEFAULT '',
+    started_at   TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at  TIMESTAMPTZ,
+    duration_ms  BIGINT      NOT NULL DEFAULT 0,
+    UNIQUE (task_id, keyword)
+);
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// SaveProxyHealth 保存代理节点的健康评分，data为JSON格式
+func (db *PostgresDB) SaveProxyHealth(node string, data []byte) error {
+	query := `INSERT INTO proxy_node_health (node, data, updated_at)
+	         VALUES ($1, $2, CURRENT_TIMESTAMP)
+	         ON CONFLICT (node) DO UPDATE SET data = EXCLUDED.data, updated_at = CURRENT_TIMESTAMP`
+	_, err := db.pool.Exec(context.Background(), query, node, data)
+	if err != nil {
+		return fmt.Errorf("保存节点健康评分失败: %v", err)
+	}
+	return nil
+}
+
+// LoadProxyHealth 查询全部代理节点的健康评分，返回节点名称到JSON数据的映射
+func (db *PostgresDB) LoadProxyHealth() (map[string][]byte, error) {
+	rows, err := db.pool.Query(context.Background(), `SELECT node, data FROM proxy_node_health`)
+	if err != nil {
+		return nil, fmt.Errorf("查询节点健康评分失败: %v", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string][]byte)
+	for rows.Next() {
+		var node string
+		var data []byte
+		if err := rows.Scan(&node, &data); err != nil {
+			return nil, fmt.Errorf("读取节点健康评分失败: %v", err)
+		}
+		result[node] = data
+	}
+	return result, rows.Err()
+}
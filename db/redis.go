@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient 根据configs表中type="redis"的连接字符串创建Redis客户端，并检查连接
+func (db *PostgresDB) NewRedisClient() (*redis.Client, error) {
+	// 从数据库获取Redis连接字符串
+	redisURL, err := db.GetRedisConfig()
+	if err != nil {
+		return nil, fmt.Errorf("获取Redis连接字符串失败: %v", err)
+	}
+	return NewRedisClientFromURL(redisURL)
+}
+
+// NewRedisClientFromURL 根据连接字符串创建Redis客户端，并检查连接
+func NewRedisClientFromURL(redisURL string) (*redis.Client, error) {
+	// 创建Redis客户端
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("解析Redis连接字符串失败: %v", err)
+	}
+
+	// 确保认证信息正确设置
+	// 如果连接字符串中包含用户名和密码，但ParseURL没有正确解析，手动设置
+	if opts.Password == "" && strings.Contains(redisURL, "@") {
+		// 尝试从URL中提取认证信息
+		parts := strings.Split(redisURL, "@")
+		if len(parts) >= 2 {
+			protocolParts := strings.Split(parts[0], "://")
+			if len(protocolParts) >= 2 {
+				auth := protocolParts[1]
+				if strings.Contains(auth, ":") {
+					// 格式: username:password
+					credentials := strings.Split(auth, ":")
+					if len(credentials) == 2 {
+						opts.Username = credentials[0]
+						opts.Password = credentials[1]
+					}
+				} else {
+					// 格式: redis://username@host:port/db
+					// 在这种情况下，username就是密码
+					opts.Password = auth
+				}
+			}
+		}
+	}
+
+	client := redis.NewClient(opts)
+
+	// 检查连接
+	if _, err := client.Ping(context.Background()).Result(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("Redis连接测试失败: %v", err)
+	}
+	return client, nil
+}
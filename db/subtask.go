@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Subtask 表示keywords_scrapy_subtask表中一个关键词的执行情况
+type Subtask struct {
+	TaskID      string
+	Keyword     string
+	Status      string
+	Pages       int
+	FailedPages int
+	Products    int
+	ASINCount   int
+	ErrorClass  string
+	ErrMsg      string
+	StartedAt   time.Time
+	FinishedAt  time.Time
+}
+
+// StartSubtask 记录关键词开始执行，任务重新执行时覆盖上一次的记录
+func (db *PostgresDB) StartSubtask(taskID string, keyword string, startedAt time.Time) error {
+	query := `INSERT INTO keywords_scrapy_subtask (task_id, keyword, status, started_at)
+	         VALUES ($1, $2, '执行中', $3)
+	         ON CONFLICT (task_id, keyword) DO UPDATE SET status = '执行中', pages = 0, failed_pages = 0,
+	             products = 0, asin_num = 0, error_class = '', err_msg = '', started_at = EXCLUDED.started_at,
+	             finished_at = NULL, duration_ms = 0`
+	_, err := db.pool.Exec(context.Background(), query, taskID, keyword, startedAt)
+	if err != nil {
+		return fmt.Errorf("记录关键词子任务失败: %v", err)
+	}
+	return nil
+}
+
+// FinishSubtask 记录关键词的执行结果
+func (db *PostgresDB) FinishSubtask(subtask Subtask) error {
+	query := `INSERT INTO keywords_scrapy_subtask (task_id, keyword, status, pages, failed_pages, products, asin_num,
+	             error_class, err_msg, started_at, finished_at, duration_ms)
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	         ON CONFLICT (task_id, keyword) DO UPDATE SET status = EXCLUDED.status, pages = EXCLUDED.pages,
+	             failed_pages = EXCLUDED.failed_pages, products = EXCLUDED.products, asin_num = EXCLUDED.asin_num,
+	             error_class = EXCLUDED.error_class, err_msg = EXCLUDED.err_msg, started_at = EXCLUDED.started_at,
+	             finished_at = EXCLUDED.finished_at, duration_ms = EXCLUDED.duration_ms`
+	_, err := db.pool.Exec(context.Background(), query, subtask.TaskID, subtask.Keyword, subtask.Status,
+		subtask.Pages, subtask.FailedPages, subtask.Products, subtask.ASINCount, subtask.ErrorClass, subtask.ErrMsg,
+		subtask.StartedAt, subtask.FinishedAt, subtask.FinishedAt.Sub(subtask.StartedAt).Milliseconds())
+	if err != nil {
+		return fmt.Errorf("更新关键词子任务失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"awesomeProject/parser"
+	"awesomeProject/proxy"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/go-resty/resty/v2"
+)
+
+// 封禁类型
+const (
+	BlockThrottle  = "503"
+	BlockCaptcha   = "captcha"
+	BlockConnReset = "conn_reset"
+)
+
+// ErrBlocked 表示请求在用完所有重试次数后仍被封禁
+var ErrBlocked = errors.New("请求被封禁")
+
+// Attempt 表示一次请求尝试
+type Attempt struct {
+	Node       string    `json:"node"`
+	StatusCode int       `json:"status_code"`
+	Block      string    `json:"block,omitempty"`
+	Class      string    `json:"class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Rotated    bool      `json:"rotated"`
+	Time       time.Time `json:"time"`
+	// LatencyMs 请求耗时，BackoffMs 重试前等待的时长
+	LatencyMs int64 `json:"latency_ms"`
+	BackoffMs int64 `json:"backoff_ms,omitempty"`
+}
+
+// Fetcher 封装带代理的HTTP客户端，按重试策略重试同一URL，遇到封禁时切换节点
+type Fetcher struct {
+	Client *resty.Client
+
+	// ctx 任务的上下文，取消后不再发起新的请求
+	ctx context.Context
+
+	provider proxy.ProxyProvider
+	endpoint *proxy.Endpoint
+	country  string
+	zipCode  string
+	retry    *RetryPolicy
+	headers  map[string]string
+	profile  *HeaderProfile
+	traffic  *TrafficStats
+
+	// 从会话池租用的会话，Close时归还，被封禁时淘汰
+	pool    *SessionPool
+	session *Session
+}
+
+// newFetcher 按任务指定的代理类型创建Fetcher
+func newFetcher(ctx context.Context, task *Task) *Fetcher {
+	proxyKind := task.Proxy
+	if cache := getResponseCache(); cache != nil && cache.Mode == CacheOnly {
+		// 只读取缓存时不需要代理
+		proxyKind = proxy.ProviderDirect
+	}
+	provider, err := getProxyProvider(proxyKind)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	endpoint, err := provider.Next(ctx, task.Code)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	fmt.Printf("使用代理%s节点: %s\n", provider.Name(), endpoint.Node)
+
+	// 创建resty客户端并设置代理
+	client := resty.New()
+	if endpoint.URL != "" {
+		client.SetProxy(endpoint.URL)
+	}
+	client.SetTimeout(30 * time.Second)
+
+	if task.Traffic == nil {
+		task.Traffic = NewTrafficStats()
+	}
+	if task.RetryBudget == nil {
+		task.RetryBudget = NewRetryBudget()
+	}
+	f := &Fetcher{
+		Client:   client,
+		ctx:      ctx,
+		provider: provider,
+		endpoint: endpoint,
+		country:  task.Code,
+		retry:    NewRetryPolicy(task.RetryBudget),
+		traffic:  task.Traffic,
+	}
+	f.useProfile(NewHeaderProfile(task.Code))
+	getRateLimiter().Attach(client, f.Node)
+	// 统计经过该客户端的全部请求，包括设置邮编等不经过Get的请求，字节数按连接统计
+	countWireBytes(client, f.Node, f.traffic)
+	client.OnAfterResponse(func(_ *resty.Client, _ *resty.Response) error {
+		f.traffic.Add(f.endpoint.Node, TrafficCounter{Requests: 1})
+		return nil
+	})
+	client.OnError(func(_ *resty.Request, _ error) {
+		f.traffic.Add(f.endpoint.Node, TrafficCounter{Requests: 1})
+	})
+	return f
+}
+
+// useProfile 切换请求头Profile并写入站点的语言和货币偏好Cookie
+func (f *Fetcher) useProfile(profile *HeaderProfile) {
+	f.profile = profile
+	f.headers = profile.Headers()
+	profile.Apply(f.Client)
+}
+
+// Close 归还会话和代理出口
+func (f *Fetcher) Close() {
+	if f.pool != nil {
+		f.pool.Return(f, f.session)
+	}
+	f.provider.Release(f.endpoint)
+}
+
+// Node 返回当前使用的节点名称
+func (f *Fetcher) Node() string {
+	return f.endpoint.Node
+}
+
+// Get 请求页面，启用响应缓存时按缓存模式先读取缓存，请求成功的页面写入缓存
+func (f *Fetcher) Get(rawURL string) (*resty.Response, []Attempt, error) {
+	if resp, attempts, err := f.cachedGet(rawURL); resp != nil || err != nil {
+		return resp, attempts, err
+	}
+	resp, attempts, err := f.fetch(rawURL)
+	if err == nil {
+		f.storeResponse(rawURL, resp)
+	}
+	return resp, attempts, err
+}
+
+// fetch 请求URL，按错误分类重试：超时、连接错误、5xx、503和验证码在退避后重试，
+// 封禁和网络错误重试前切换节点，返回最后一次响应和全部尝试记录
+func (f *Fetcher) fetch(rawURL string) (*resty.Response, []Attempt, error) {
+	attempts := []Attempt{}
+	classAttempts := make(map[string]int)
+	rotated := false
+	for i := 1; ; i++ {
+		if err := f.ctx.Err(); err != nil {
+			return nil, attempts, err
+		}
+		if err := f.consumeBudget(); err != nil {
+			return nil, attempts, err
+		}
+		start := time.Now()
+		resp, err := f.Client.R().SetContext(f.ctx).SetHeaders(f.headers).Get(rawURL)
+		latency := time.Since(start)
+		if ctxErr := f.ctx.Err(); ctxErr != nil {
+			// 任务被取消，不是节点的问题，不计入健康评分
+			return resp, attempts, ctxErr
+		}
+
+		attempt := Attempt{Node: f.endpoint.Node, Rotated: rotated, Time: time.Now(), LatencyMs: latency.Milliseconds()}
+		if resp != nil {
+			attempt.StatusCode = resp.StatusCode()
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		attempt.Block = detectBlock(resp, err)
+		attempt.Class = classifyError(resp, err, attempt.Block)
+		f.report(attempt, err, latency)
+		f.countAttempt(attempt, i)
+
+		if attempt.Class == "" {
+			attempts = append(attempts, attempt)
+			return resp, attempts, err
+		}
+		classAttempts[attempt.Class]++
+		rule := f.retry.Rule(attempt.Class)
+		if classAttempts[attempt.Class] >= rule.MaxAttempts || i > f.retry.PageBudget || !f.retry.Budget.Take() {
+			attempts = append(attempts, attempt)
+			return resp, attempts, retryError(attempt, err)
+		}
+		backoff := f.retry.Backoff(classAttempts[attempt.Class])
+		attempt.BackoffMs = backoff.Milliseconds()
+		attempts = append(attempts, attempt)
+
+		rotated = false
+		if rule.Rotate {
+			logs.Warn(fmt.Sprintf("节点 %s 请求失败(%s)，%s后切换节点重试: %s", f.endpoint.Node, attempt.Class, backoff, rawURL))
+			retired := attempt.Block != "" && f.retireSession()
+			rotated = f.rotate() == nil
+			if retired {
+				f.renewSession()
+			}
+		} else {
+			logs.Warn(fmt.Sprintf("请求失败(%s)，%s后重试: %s", attempt.Class, backoff, rawURL))
+		}
+		if err := sleepContext(f.ctx, backoff); err != nil {
+			return resp, attempts, err
+		}
+	}
+}
+
+// consumeBudget 记录当前节点的一次请求，节点本分钟的请求预算用完时先切换节点，
+// 没有可切换的节点时等到下一分钟预算恢复，任务被取消时返回错误
+func (f *Fetcher) consumeBudget() error {
+	limiter, ok := f.provider.(proxy.BudgetLimiter)
+	if !ok {
+		return nil
+	}
+	for !limiter.Consume(f.endpoint) {
+		logs.Info(fmt.Sprintf("节点 %s 本分钟请求数已达上限，切换节点", f.endpoint.Node))
+		if f.rotate() == nil {
+			continue
+		}
+		wait := time.Until(time.Now().Truncate(time.Minute).Add(time.Minute))
+		logs.Warn(fmt.Sprintf("没有请求预算未用完的节点，等待%s", wait.Round(time.Second)))
+		if err := sleepContext(f.ctx, wait); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countAttempt 统计封禁和重试次数，请求数和字节数由客户端钩子统计
+func (f *Fetcher) countAttempt(attempt Attempt, i int) {
+	counter := TrafficCounter{}
+	if attempt.Block != "" {
+		counter.Blocked = 1
+	}
+	if i > 1 {
+		counter.Retries = 1
+	}
+	if counter != (TrafficCounter{}) {
+		f.traffic.Add(attempt.Node, counter)
+	}
+}
+
+// report 将请求结果反馈给支持健康评分的代理提供者
+func (f *Fetcher) report(attempt Attempt, err error, latency time.Duration) {
+	reporter, ok := f.provider.(proxy.HealthReporter)
+	if !ok {
+		return
+	}
+	outcome := proxy.OutcomeSuccess
+	switch {
+	case attempt.Block == BlockThrottle:
+		outcome = proxy.OutcomeThrottled
+	case attempt.Block == BlockCaptcha:
+		outcome = proxy.OutcomeCaptcha
+	case isTimeout(err):
+		outcome = proxy.OutcomeTimeout
+	case err != nil || attempt.Block != "":
+		outcome = proxy.OutcomeError
+	}
+	reporter.Report(attempt.Node, outcome, latency)
+}
+
+// rotate 切换到另一个代理出口
+func (f *Fetcher) rotate() error {
+	endpoint, err := f.provider.Rotate(f.ctx, f.endpoint, f.country)
+	if err != nil {
+		logs.Warn("切换代理节点失败:", err)
+		return err
+	}
+	f.endpoint = endpoint
+	if endpoint.URL != "" {
+		f.Client.SetProxy(endpoint.URL)
+	}
+	// 同一端口切换节点后，关闭已建立的连接，避免继续复用旧节点的连接
+	f.Client.GetClient().CloseIdleConnections()
+	logs.Info("切换为", endpoint.Node)
+	return nil
+}
+
+// newPageResult 根据请求结果创建页面记录
+func newPageResult(page int, pageURL string, resp *resty.Response, attempts []Attempt) PageResult {
+	record := PageResult{Page: page, URL: pageURL, Attempts: attempts}
+	if resp != nil {
+		record.StatusCode = resp.StatusCode()
+	}
+	for _, attempt := range attempts {
+		if attempt.Rotated {
+			record.Rotations++
+		}
+	}
+	return record
+}
+
+// detectBlock 判断响应是否为封禁，返回封禁类型，未封禁时返回空字符串
+func detectBlock(resp *resty.Response, err error) string {
+	if err != nil {
+		if errors.Is(err, syscall.ECONNRESET) || strings.Contains(err.Error(), "connection reset") ||
+			strings.Contains(err.Error(), "EOF") {
+			return BlockConnReset
+		}
+		return ""
+	}
+	if resp == nil {
+		return ""
+	}
+	if resp.StatusCode() == 503 {
+		return BlockThrottle
+	}
+	if resp.StatusCode() == 200 && parser.IsCaptchaPage(resp.String()) {
+		return BlockCaptcha
+	}
+	return ""
+}
+
+// sleepContext 等待指定时长，ctx取消时提前返回ctx的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// envDuration 读取时长环境变量，例如30s、5m，未设置或格式错误时返回默认值
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// envInt 读取整数环境变量，未设置或格式错误时返回默认值
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"awesomeProject/db"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+)
+
+// errLeaseLost 任务租约过期后已被回收，任务可能已由其他worker执行
+var errLeaseLost = errors.New("任务租约已失效")
+
+// LeaseWorker 直接从keywords_scrapy_task表领取待执行的任务(SELECT ... FOR UPDATE SKIP LOCKED)，
+// 执行期间定期续期租约。worker崩溃后租约过期，任务由其他worker回收并重新执行，最多执行max_attempts次
+type LeaseWorker struct {
+	ID          string
+	Codes       []string
+	Concurrency int
+	// LeaseTTL 租约有效期，每1/3有效期续期一次
+	LeaseTTL time.Duration
+	// PollInterval 没有收到新任务通知时查询待执行任务的间隔
+	PollInterval time.Duration
+
+	postgresDB *db.PostgresDB
+	proxyKind  string
+	wake       chan struct{}
+}
+
+// NewLeaseWorker 创建worker，codes为空时领取全部国家的任务，参数来自环境变量WORKER_LEASE_TTL和WORKER_POLL_INTERVAL
+func NewLeaseWorker(postgresDB *db.PostgresDB, proxyKind string, codes []string, concurrency int) *LeaseWorker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &LeaseWorker{
+		ID:           newWorkerID(),
+		Codes:        codes,
+		Concurrency:  concurrency,
+		LeaseTTL:     envDuration("WORKER_LEASE_TTL", 2*time.Minute),
+		PollInterval: envDuration("WORKER_POLL_INTERVAL", 30*time.Second),
+		postgresDB:   postgresDB,
+		proxyKind:    proxyKind,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Run 领取并执行任务直到ctx取消，取消后不再领取新任务，等待执行中的任务结束
+func (w *LeaseWorker) Run(ctx context.Context) error {
+	go w.listen(ctx)
+	go w.reclaimLoop(ctx)
+
+	logs.Info(fmt.Sprintf("worker %s 开始领取任务%v，并发数%d", w.ID, w.Codes, w.Concurrency))
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+	logs.Info(fmt.Sprintf("worker %s 已退出", w.ID))
+	return nil
+}
+
+// listen 收到新任务通知时唤醒一个空闲的协程
+func (w *LeaseWorker) listen(ctx context.Context) {
+	for code := range w.postgresDB.ListenTasks(ctx) {
+		if len(w.Codes) == 0 || slices.Contains(w.Codes, code) {
+			w.notify()
+		}
+	}
+}
+
+func (w *LeaseWorker) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reclaimLoop 定期回收租约已过期的任务，回收后的任务变为待执行并触发通知
+func (w *LeaseWorker) reclaimLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.LeaseTTL / 2)
+	defer ticker.Stop()
+	for {
+		count, err := w.postgresDB.ReclaimExpiredTasks()
+		if err != nil {
+			logs.Warn("回收过期任务失败:", err)
+		} else if count > 0 {
+			logs.Warn(fmt.Sprintf("已回收%d个租约过期的任务", count))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// loop 循环领取并执行任务，没有待执行的任务时等待通知或下一次轮询
+func (w *LeaseWorker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		task, err := w.postgresDB.LeaseTask(w.ID, w.LeaseTTL, w.Codes)
+		if err != nil {
+			logs.Warn(err)
+			sleepContext(ctx, w.PollInterval)
+			continue
+		}
+		if task == nil {
+			select {
+			case <-ctx.Done():
+			case <-w.wake:
+			case <-time.After(w.PollInterval):
+			}
+			continue
+		}
+		// 可能还有待执行的任务，唤醒其他空闲的协程
+		w.notify()
+		w.handle(ctx, task)
+	}
+}
+
+// handle 执行领取到的任务并续期租约，任务结束后释放租约；任务panic或worker收到退出信号时放回待执行
+func (w *LeaseWorker) handle(ctx context.Context, task *db.LeasedTask) {
+	logs.Info(fmt.Sprintf("开始执行任务%s(%s，第%d/%d次)", task.TaskID, task.CountryCode, task.Attempts, task.MaxAttempts))
+	taskCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	taskCtx, state := withRequeue(taskCtx)
+
+	stopHeartbeat := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		w.heartbeat(task.TaskID, stopHeartbeat, cancel)
+	}()
+	result, panicked := runTaskSafely(taskCtx, w.postgresDB, task.TaskID, w.proxyKind)
+	close(stopHeartbeat)
+	<-heartbeatDone
+
+	switch {
+	case errors.Is(context.Cause(taskCtx), errLeaseLost):
+		logs.Warn(fmt.Sprintf("任务%s的租约已失效，不再更新任务状态", task.TaskID))
+		return
+	case panicked && state.saved.Load():
+		// 结果已经保存，重新执行会重复保存，标记任务失败
+		if err := w.postgresDB.UpdateTaskFailed(task.TaskID, "任务执行过程中发生panic"); err != nil {
+			logs.Err(err)
+		}
+	case panicked:
+		if err := w.postgresDB.RequeueTask(task.TaskID, w.ID, "任务执行过程中发生panic"); err != nil {
+			logs.Err(err)
+		}
+		return
+	case errors.Is(context.Cause(taskCtx), errShutdown) && !state.saved.Load():
+		// 没有保存结果，放回待执行由其他worker执行，本次不计入执行次数
+		if err := w.postgresDB.ReturnTask(task.TaskID, w.ID, fmt.Sprintf("worker退出(%v)，任务放回待执行", context.Cause(taskCtx))); err != nil {
+			logs.Err(err)
+		}
+		logs.Info(fmt.Sprintf("任务%s被退出信号打断，已放回待执行", task.TaskID))
+		return
+	}
+	if err := w.postgresDB.ReleaseTask(task.TaskID, w.ID); err != nil {
+		logs.Err(err)
+	}
+	logs.Info(fmt.Sprintf("任务%s执行结束: %s", task.TaskID, result))
+}
+
+// heartbeat 定期续期租约直到stop关闭，租约已被回收时取消任务
+func (w *LeaseWorker) heartbeat(taskID string, stop <-chan struct{}, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(w.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ok, err := w.postgresDB.HeartbeatTask(taskID, w.ID, w.LeaseTTL)
+			if err != nil {
+				// 暂时无法续期，租约过期前还有机会重试
+				logs.Warn(err)
+				continue
+			}
+			if !ok {
+				cancel(errLeaseLost)
+				return
+			}
+		}
+	}
+}
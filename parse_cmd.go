@@ -0,0 +1,129 @@
+package main
+
+import (
+	"awesomeProject/parser"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ParsedFile 表示一个离线HTML文件的解析结果
+type ParsedFile struct {
+	File   string      `json:"file"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// runParseCommand 解析离线保存的HTML文件并以JSON输出，返回进程退出码
+// 用法: awesome parse --type search --code DE page1.html page2.html
+func runParseCommand(args []string) int {
+	fs := flag.NewFlagSet("parse", flag.ExitOnError)
+	pageType := fs.String("type", "search", "页面类型: search 或 product")
+	code := fs.String("code", "US", "站点国家代码，例如US、DE、JP")
+	page := fs.Int("page", 1, "搜索结果页码，多个文件时按文件顺序递增")
+	query := fs.String("query", "", "搜索关键词，用于识别纠正后的关键词")
+	pretty := fs.Bool("pretty", false, "格式化输出JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: awesome parse [参数] <HTML文件或目录>...")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *pageType != "search" && *pageType != "product" {
+		fmt.Fprintf(os.Stderr, "不支持的页面类型: %s\n", *pageType)
+		return 2
+	}
+
+	files, err := collectHTMLFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	results := make([]ParsedFile, 0, len(files))
+	failed := 0
+	for i, file := range files {
+		parsed := ParsedFile{File: file}
+		result, err := parseHTMLFile(file, *pageType, parser.Options{
+			Code:  strings.ToUpper(*code),
+			Page:  *page + i,
+			Query: *query,
+		})
+		if err != nil {
+			parsed.Error = err.Error()
+			failed++
+		} else {
+			parsed.Result = result
+		}
+		results = append(results, parsed)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	if *pretty {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(results); err != nil {
+		fmt.Fprintf(os.Stderr, "输出JSON失败: %v\n", err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseHTMLFile 按页面类型解析单个HTML文件
+func parseHTMLFile(file string, pageType string, opts parser.Options) (interface{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer f.Close()
+
+	if pageType == "product" {
+		return parser.ParseProduct(f, opts)
+	}
+	return parser.ParseSearch(f, opts)
+}
+
+// collectHTMLFiles 展开参数中的目录，返回按文件名排序的HTML文件列表
+func collectHTMLFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取文件失败: %v", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		dirFiles := []string{}
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := strings.ToLower(filepath.Ext(p))
+			if !fi.IsDir() && (ext == ".html" || ext == ".htm") {
+				dirFiles = append(dirFiles, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("遍历目录失败: %v", err)
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
@@ -0,0 +1,212 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// BadgeType 表示搜索卡片上的徽章类型
+type BadgeType string
+
+const (
+	BadgeAmazonChoice    BadgeType = "amazon_choice"
+	BadgeOverallPick     BadgeType = "overall_pick"
+	BadgeBestSeller      BadgeType = "best_seller"
+	BadgeAmazonPrime     BadgeType = "amazon_prime"
+	BadgeClimatePledge   BadgeType = "climate_pledge_friendly"
+	BadgeSmallBusiness   BadgeType = "small_business"
+	BadgeLimitedDeal     BadgeType = "limited_time_deal"
+	BadgeNewRelease      BadgeType = "new_release"
+	BadgeBoughtPastMonth BadgeType = "bought_past_month"
+)
+
+// Badge 表示产品卡片上的一个徽章
+type Badge struct {
+	Type  BadgeType `json:"type" bson:"type"`
+	Label string    `json:"label" bson:"label"`
+}
+
+// badgeLabels 各站点语言下徽章的显示文本（小写，完全匹配）
+var badgeLabels = map[BadgeType][]string{
+	BadgeAmazonChoice: {
+		"amazon's choice", "amazon’s choice", // US/UK/CA/AU
+		"amazons tipp",                     // DE
+		"choix d'amazon", "choix d’amazon", // FR
+		"scelta amazon",                       // IT
+		"elección de amazon", "opción amazon", // ES/MX
+		"amazon おすすめ", "amazonおすすめ", // JP
+	},
+	BadgeOverallPick: {
+		"overall pick",
+		"top-empfehlung",
+		"meilleur choix global",
+		"scelta complessiva",
+		"mejor opción general",
+		"総合おすすめ",
+	},
+	BadgeBestSeller: {
+		"best seller", "#1 best seller",
+		"bestseller", "nr. 1 bestseller",
+		"n° 1 des ventes", "meilleure vente",
+		"più venduto", "n. 1 più venduti",
+		"más vendido", "n.º 1 más vendido",
+		"ベストセラー", "ベストセラー1位",
+	},
+	BadgeClimatePledge: {
+		"climate pledge friendly",
+	},
+	BadgeSmallBusiness: {
+		"small business",
+		"kleine unternehmen",
+		"petites entreprises",
+		"piccole e medie imprese",
+		"pequeñas empresas", "pequeña empresa",
+		"中小企業",
+	},
+	BadgeLimitedDeal: {
+		"limited time deal",
+		"befristetes angebot", "zeitlich begrenztes angebot",
+		"offre à durée limitée",
+		"offerta a tempo limitato", "offerta a tempo",
+		"oferta por tiempo limitado", "oferta relámpago",
+		"タイムセール", "特選タイムセール",
+	},
+	BadgeNewRelease: {
+		"new release",
+		"neuerscheinung",
+		"nouveauté",
+		"novità",
+		"novedad",
+		"新着",
+	},
+}
+
+// boughtPastMonthPatterns 各站点语言下"过去一个月购买量"的匹配规则，第一个分组为数量部分。
+// 数量可以写成"1K+ …"，也可以写成"More than 1,000 …"、"Mehr als 1.000 …"、"Más de 1 mil …"
+var boughtPastMonthPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:(?:more than|over)\s+)?([\d.,\s\x{00a0}\x{202f}]+\s*k?)\+?\s*bought in past month`),                     // EN
+	regexp.MustCompile(`(?i)(?:mehr als\s+)?([\d.,\s\x{00a0}\x{202f}]+\s*(?:k|tsd\.?)?)\+?\s*mal im letzten monat gekauft`),            // DE
+	regexp.MustCompile(`(?i)(?:plus de\s+)?([\d.,\s\x{00a0}\x{202f}]+\s*k?)\+?\s*achetés (?:au cours du|le) mois dernier`),             // FR
+	regexp.MustCompile(`(?i)(?:(?:più di|oltre)\s+)?([\d.,\s\x{00a0}\x{202f}]+\s*(?:k|mila)?)\+?\s*acquistati (?:nel|il) mese scorso`), // IT
+	regexp.MustCompile(`(?i)(?:más de\s+)?([\d.,\s\x{00a0}\x{202f}]+\s*(?:k|mil)?)\+?\s*comprados el mes pasado`),                      // ES/MX
+	regexp.MustCompile(`過去1か月で([\d.,]+\s*[千万]?)点以上購入`),                                                                                 // JP
+}
+
+// ExtractBadges 从搜索结果卡片中提取所有徽章
+func ExtractBadges(item *goquery.Selection, asin string) []Badge {
+	badges := []Badge{}
+	seen := make(map[BadgeType]bool)
+	add := func(t BadgeType, label string) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		badges = append(badges, Badge{Type: t, Label: label})
+	}
+
+	// 带固定id的徽章
+	if asin != "" {
+		if s := item.Find("span[id='" + asin + "-amazons-choice']"); s.Length() > 0 {
+			add(BadgeAmazonChoice, strings.TrimSpace(s.Find(".a-badge-text").Text()))
+		}
+		if s := item.Find("span[id='" + asin + "-best-seller']"); s.Length() > 0 {
+			add(BadgeBestSeller, strings.TrimSpace(s.Find(".a-badge-text").Text()))
+		}
+	}
+	if item.Find(".s-prime, i.a-icon-prime").Length() > 0 {
+		add(BadgeAmazonPrime, "Prime")
+	}
+
+	// 按文本匹配其余徽章，只检查叶子节点，避免标题中的同名词误判
+	item.Find("span, a, div").Each(func(_ int, s *goquery.Selection) {
+		if s.Children().Length() > 0 {
+			return
+		}
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" || len(text) > 80 {
+			return
+		}
+		if t, ok := matchBadgeLabel(text); ok {
+			add(t, text)
+			return
+		}
+		if !seen[BadgeBoughtPastMonth] && ParseBoughtPastMonth(text) > 0 {
+			add(BadgeBoughtPastMonth, text)
+		}
+	})
+
+	return badges
+}
+
+// matchBadgeLabel 判断文本是否为已知徽章文本
+func matchBadgeLabel(text string) (BadgeType, bool) {
+	lower := strings.ToLower(text)
+	for t, labels := range badgeLabels {
+		for _, label := range labels {
+			if lower == label {
+				return t, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ParseBoughtPastMonth 将"1K+ bought in past month"等文本转换为购买量下限，无法识别时返回0
+func ParseBoughtPastMonth(text string) int {
+	for _, re := range boughtPastMonthPatterns {
+		matches := re.FindStringSubmatch(text)
+		if len(matches) > 1 {
+			return parseQuantity(matches[1])
+		}
+	}
+	return 0
+}
+
+// parseQuantity 解析带有千位分隔符或K/Tsd./mil/千/万等单位的数量
+func parseQuantity(s string) int {
+	s = strings.ToLower(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix string
+		value  float64
+	}{{"mila", 1000}, {"mil", 1000}, {"tsd.", 1000}, {"tsd", 1000}, {"k", 1000}, {"千", 1000}, {"万", 10000}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.value
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\u00a0' || r == '\u202f' {
+			return -1
+		}
+		return r
+	}, s)
+	if multiplier > 1 {
+		// 带单位时分隔符为小数点，例如1.5K、1,5 k
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		// 不带单位时分隔符为千位分隔符，例如1,000、1.000
+		s = strings.ReplaceAll(strings.ReplaceAll(s, ",", ""), ".", "")
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return int(value * multiplier)
+}
+
+// HasBadge 判断徽章列表中是否包含指定类型
+func HasBadge(badges []Badge, t BadgeType) bool {
+	for _, b := range badges {
+		if b.Type == t {
+			return true
+		}
+	}
+	return false
+}
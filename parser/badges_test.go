@@ -0,0 +1,70 @@
+package parser
+
+import "testing"
+
+func TestParseBoughtPastMonth(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"1K+ bought in past month", 1000},
+		{"50+ bought in past month", 50},
+		{"More than 1,000 bought in past month", 1000},
+		{"Over 2K bought in past month", 2000},
+		{"1.000+ Mal im letzten Monat gekauft", 1000},
+		{"Mehr als 1.000 Mal im letzten Monat gekauft", 1000},
+		{"Mehr als 5 Tsd. Mal im letzten Monat gekauft", 5000},
+		{"1 k+ achetés au cours du mois dernier", 1000},
+		{"Plus de 1 000 achetés au cours du mois dernier", 1000},
+		{"1000+ acquistati nel mese scorso", 1000},
+		{"Più di 1000 acquistati nel mese scorso", 1000},
+		{"Oltre 2 mila acquistati nel mese scorso", 2000},
+		{"1 mil+ comprados el mes pasado", 1000},
+		{"Más de 1 mil comprados el mes pasado", 1000},
+		{"過去1か月で1000点以上購入されました", 1000},
+		{"過去1か月で1万点以上購入されました", 10000},
+		{"Free delivery", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseBoughtPastMonth(tt.text); got != tt.want {
+			t.Errorf("ParseBoughtPastMonth(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"1K", 1000},
+		{"1.5K", 1500},
+		{"1,5 k", 1500},
+		{"1,000", 1000},
+		{"1.000", 1000},
+		{"1 000", 1000},
+		{"1 000", 1000},
+		{"1 000", 1000},
+		{"2 mila", 2000},
+		{"3 mil", 3000},
+		{"5 tsd.", 5000},
+		{"2千", 2000},
+		{"1万", 10000},
+		{"", 0},
+		{"abc", 0},
+	}
+	for _, tt := range tests {
+		if got := parseQuantity(tt.in); got != tt.want {
+			t.Errorf("parseQuantity(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchBadgeLabel(t *testing.T) {
+	if _, ok := matchBadgeLabel("Gesamtbewertung"); ok {
+		t.Error("Gesamtbewertung is a rating label, not a badge")
+	}
+	if got, ok := matchBadgeLabel("Top-Empfehlung"); !ok || got != BadgeOverallPick {
+		t.Errorf("matchBadgeLabel(Top-Empfehlung) = %q, %v", got, ok)
+	}
+}
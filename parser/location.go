@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LocationModal 表示首页导航栏中"选择配送地址"弹窗的请求信息
+type LocationModal struct {
+	// URL 弹窗内容的地址，为相对路径
+	URL string
+	// Token 请求弹窗时需要携带的anti-csrftoken-a2z请求头
+	Token string
+}
+
+var csrfTokenRe = regexp.MustCompile(`CSRF_TOKEN\s*:\s*["']([^"']+)["']`)
+
+// ParseLocationModal 从首页中提取配送地址弹窗的地址和令牌，页面中没有弹窗时返回nil
+func ParseLocationModal(doc *goquery.Document) *LocationModal {
+	data, ok := doc.Find("#nav-global-location-data-modal-action").Attr("data-a-modal")
+	if !ok {
+		return nil
+	}
+	modal := struct {
+		URL         string            `json:"url"`
+		AjaxHeaders map[string]string `json:"ajaxHeaders"`
+	}{}
+	if err := json.Unmarshal([]byte(data), &modal); err != nil {
+		return nil
+	}
+	return &LocationModal{URL: modal.URL, Token: modal.AjaxHeaders["anti-csrftoken-a2z"]}
+}
+
+// ParseCSRFToken 从配送地址弹窗的内容中提取提交邮编时使用的令牌
+func ParseCSRFToken(html string) string {
+	if m := csrfTokenRe.FindStringSubmatch(html); len(m) > 1 {
+		return m[1]
+	}
+	return ""
+}
+
+// DeliveryLocation 返回导航栏中显示的配送地址，例如"New York 10001"
+func DeliveryLocation(doc *goquery.Document) string {
+	return cleanText(doc.Find("#glow-ingress-line2").First().Text())
+}
+
+// LocationMatches 判断导航栏中的配送地址是否为指定邮编，按完整的邮编词比较，避免"10001"匹配"1000"。
+// 导航栏可能截断较长的邮编(例如英国"SW1A 1AA"显示为"SW1A 1")，此时外码必须相同，
+// 显示出来的内码必须是邮编内码的开头
+func LocationMatches(location string, zipCode string) bool {
+	tokens := postcodeTokens(location)
+	parts := postcodeTokens(zipCode)
+	if len(tokens) == 0 || len(parts) == 0 {
+		return false
+	}
+	whole := strings.Join(parts, "")
+	for i, token := range tokens {
+		if token == whole || i+1 < len(tokens) && token+tokens[i+1] == whole {
+			return true
+		}
+		if len(parts) < 2 || token != parts[0] {
+			continue
+		}
+		// 外码相同，内码以数字开头，后面不是数字开头的词时说明导航栏只显示了外码
+		if i+1 == len(tokens) || !unicode.IsDigit(rune(tokens[i+1][0])) {
+			return true
+		}
+		return strings.HasPrefix(parts[1], tokens[i+1])
+	}
+	return false
+}
+
+// postcodeTokens 将地址拆分为大写的词，去掉连字符(日本邮编100-0001)和导航栏中的不可见字符
+func postcodeTokens(s string) []string {
+	s = strings.ToUpper(strings.NewReplacer("\u200e", "", "\u200c", "", "-", "").Replace(s))
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,32 @@
+package parser
+
+import "testing"
+
+func TestLocationMatches(t *testing.T) {
+	tests := []struct {
+		location string
+		zipCode  string
+		want     bool
+	}{
+		{"New York 10001‌", "10001", true},
+		{"New York 10001", "1000", false},
+		{"Berlin 10115", "10115", true},
+		{"Berlin 10115", "1011", false},
+		{"London SW1A 1‌", "SW1A 1AA", true},
+		{"London SW1A 1AA", "SW1A 1AA", true},
+		{"London SW1A", "SW1A 1AA", true},
+		{"London SW1A", "SW1 1AA", false},
+		{"London SW1A 2", "SW1A 1AA", false},
+		{"Toronto M5V 3L9", "M5V3L9", true},
+		{"M5V3L9", "M5V 3L9", true},
+		{"東京都 100-0001", "100-0001", true},
+		{"東京都 100-0001", "1000001", true},
+		{"Update location", "10001", false},
+		{"", "10001", false},
+	}
+	for _, tt := range tests {
+		if got := LocationMatches(tt.location, tt.zipCode); got != tt.want {
+			t.Errorf("LocationMatches(%q, %q) = %v, want %v", tt.location, tt.zipCode, got, tt.want)
+		}
+	}
+}
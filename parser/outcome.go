@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SearchOutcomeKind 表示搜索结果页的结果类型
+type SearchOutcomeKind string
+
+const (
+	OutcomeResults      SearchOutcomeKind = "results"       // 正常结果
+	OutcomeNoResults    SearchOutcomeKind = "no_results"    // 无结果
+	OutcomeCorrected    SearchOutcomeKind = "corrected"     // 已自动纠正为其他关键词
+	OutcomeDidYouMean   SearchOutcomeKind = "did_you_mean"  // 给出了拼写建议，但仍展示原关键词结果
+	OutcomePartialMatch SearchOutcomeKind = "partial_match" // 原关键词无结果，展示部分匹配的结果
+)
+
+// SearchOutcome 表示搜索结果页的检测结果
+type SearchOutcome struct {
+	Kind           SearchOutcomeKind `json:"kind"`
+	CorrectedQuery string            `json:"corrected_query,omitempty"`
+	Message        string            `json:"message,omitempty"`
+}
+
+// NoResults 判断页面是否没有任何商品。只有页面中没有商品卡片时才视为无结果，
+// 纠正关键词和部分匹配页面展示的商品照常返回，通过Kind和CorrectedQuery区分
+func (o SearchOutcome) NoResults() bool {
+	return o.Kind == OutcomeNoResults
+}
+
+// 各站点语言下的提示文本（小写，包含匹配）
+var (
+	noResultsPhrases = []string{
+		"no results for",         // EN
+		"keine ergebnisse für",   // DE
+		"aucun résultat pour",    // FR
+		"nessun risultato per",   // IT
+		"no hay resultados para", // ES/MX
+		"に一致する商品はありませんでした",       // JP
+	}
+	correctedPhrases = []string{
+		"showing results for", "search instead for", // EN
+		"stattdessen suchen nach", "ergebnisse werden angezeigt für", // DE
+		"affichage des résultats pour", "rechercher plutôt", // FR
+		"visualizzazione dei risultati per", "cerca invece", // IT
+		"mostrando resultados para", "mostrando resultados de", "buscar en su lugar", // ES/MX
+		"の検索結果を表示しています", "代わりに検索", // JP
+	}
+	didYouMeanPhrases = []string{
+		"did you mean",                                  // EN
+		"meinten sie",                                   // DE
+		"essayez-vous de chercher", "vouliez-vous dire", // FR
+		"forse cercavi", "intendevi", // IT
+		"quizás quisiste decir", "quisiste decir", // ES/MX
+		"もしかして", // JP
+	}
+	partialMatchPhrases = []string{
+		"results for fewer words", "showing results with fewer words", // EN
+		"ergebnisse für weniger wörter",  // DE
+		"résultats pour moins de mots",   // FR
+		"risultati per meno parole",      // IT
+		"resultados para menos palabras", // ES/MX
+		"一部のキーワードに一致する",                  // JP
+	}
+)
+
+// DetectSearchOutcome 识别搜索结果页中的"无结果"、"已纠正"、"您是不是要找"和部分匹配提示
+func DetectSearchOutcome(doc *goquery.Document, query string) SearchOutcome {
+	message := searchMessageText(doc)
+	lower := strings.ToLower(message)
+	hasProducts := doc.Find(".s-search-results [data-component-type='s-search-result']").Length() > 0
+
+	outcome := SearchOutcome{Kind: OutcomeResults}
+	switch {
+	case !hasProducts && containsAny(lower, noResultsPhrases):
+		outcome.Kind = OutcomeNoResults
+	case containsAny(lower, partialMatchPhrases):
+		outcome.Kind = OutcomePartialMatch
+	case containsAny(lower, noResultsPhrases) && containsAny(lower, correctedPhrases):
+		// 原关键词无结果，页面展示了纠正后关键词的商品
+		outcome.Kind = OutcomeCorrected
+	case containsAny(lower, noResultsPhrases):
+		// 原关键词无结果，但页面仍展示了相关商品
+		outcome.Kind = OutcomePartialMatch
+	case containsAny(lower, correctedPhrases):
+		outcome.Kind = OutcomeCorrected
+	case containsAny(lower, didYouMeanPhrases):
+		outcome.Kind = OutcomeDidYouMean
+	default:
+		if !hasProducts && doc.Find("[data-component-type='s-search-results']").Length() > 0 {
+			outcome.Kind = OutcomeNoResults
+		}
+		return outcome
+	}
+
+	outcome.Message = truncateMessage(message)
+	if outcome.Kind != OutcomeNoResults {
+		outcome.CorrectedQuery = correctedQuery(doc, query)
+	}
+	return outcome
+}
+
+// searchMessageText 提取搜索结果区域中除商品卡片以外的提示文本
+func searchMessageText(doc *goquery.Document) string {
+	texts := []string{}
+	region := doc.Find("[data-component-type^='s-messaging-widget']")
+	if region.Length() == 0 {
+		// 没有提示组件时，退回到整个搜索结果区域
+		region = doc.Find("[data-component-type='s-search-results']")
+	}
+	region.Each(func(_ int, s *goquery.Selection) {
+		clone := s.Clone()
+		clone.Find("[data-component-type='s-search-result'], script, style").Remove()
+		if text := strings.Join(strings.Fields(clone.Text()), " "); text != "" {
+			texts = append(texts, text)
+		}
+	})
+	return strings.Join(texts, " ")
+}
+
+// truncateMessage 截断提示文本，避免结果中保存整段页面文字
+func truncateMessage(message string) string {
+	runes := []rune(message)
+	if len(runes) > 200 {
+		return string(runes[:200])
+	}
+	return message
+}
+
+// correctedQuery 提取亚马逊实际使用或建议的关键词
+func correctedQuery(doc *goquery.Document, query string) string {
+	corrected := ""
+	doc.Find("[data-component-type^='s-messaging-widget'], [data-component-type='s-search-results'] .s-no-outline").
+		Find("span.a-color-state, span.a-text-bold, a.a-link-normal").
+		EachWithBreak(func(_ int, s *goquery.Selection) bool {
+			text := strings.Trim(strings.TrimSpace(s.Text()), `"“”「」`)
+			if text == "" || strings.EqualFold(text, query) {
+				return true
+			}
+			corrected = text
+			return false
+		})
+	return corrected
+}
+
+// containsAny 判断文本是否包含任意一个短语
+func containsAny(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package parser
+
+import "testing"
+
+func TestParseSearchOutcome(t *testing.T) {
+	tests := []struct {
+		file      string
+		code      string
+		query     string
+		kind      SearchOutcomeKind
+		corrected string
+		noResults bool
+		products  int
+	}{
+		{"search_results.html", "US", "headphones", OutcomeResults, "", false, 2},
+		{"search_corrected.html", "US", "headphnes", OutcomeCorrected, "headphones", false, 1},
+		{"search_did_you_mean.html", "US", "headphnes", OutcomeDidYouMean, "headphones", false, 1},
+		{"search_no_results.html", "US", "xyzzyqwerty", OutcomeNoResults, "", true, 0},
+		{"search_keine_ergebnisse_de.html", "DE", "kopfhörerxyz", OutcomeNoResults, "", true, 0},
+		// 原关键词无结果但页面展示了商品时，商品照常返回
+		{"search_partial_match.html", "US", "purple xyzzy headphones", OutcomePartialMatch, "purple headphones", false, 1},
+		{"search_no_results_corrected.html", "US", "headphnes", OutcomeCorrected, "headphones", false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			page, err := ParseSearch(openFixture(t, tt.file), Options{Code: tt.code, Page: 1, Query: tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Outcome.Kind != tt.kind {
+				t.Errorf("kind = %q, want %q", page.Outcome.Kind, tt.kind)
+			}
+			if page.Outcome.CorrectedQuery != tt.corrected {
+				t.Errorf("corrected query = %q, want %q", page.Outcome.CorrectedQuery, tt.corrected)
+			}
+			if page.Outcome.NoResults() != tt.noResults {
+				t.Errorf("NoResults() = %v, want %v", page.Outcome.NoResults(), tt.noResults)
+			}
+			if len(page.Products) != tt.products {
+				t.Errorf("products = %d, want %d", len(page.Products), tt.products)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// Package parser 解析亚马逊搜索结果页和商品详情页，不依赖网络请求和全局状态，
+// 可以直接用于离线HTML文件或其他服务
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version 解析器版本，修改解析逻辑时递增，写入页面归档中以便判断历史结果是否需要重新解析
+const Version = "1.0.0"
+
+// Options 表示解析时需要的上下文信息
+type Options struct {
+	// Code 站点国家代码，例如US、DE、JP
+	Code string
+	// Page 搜索结果页码，从1开始
+	Page int
+	// Query 搜索关键词，用于识别纠正后的关键词
+	Query string
+}
+
+// Position 表示产品在搜索结果中的位置
+type Position struct {
+	Page           int `json:"page"`
+	Position       int `json:"position"`
+	GlobalPosition int `json:"global_position"`
+}
+
+// Price 表示产品价格信息
+type Price struct {
+	Discounted   bool    `json:"discounted"`
+	CurrentPrice float64 `json:"current_price"`
+	BeforePrice  float64 `json:"before_price"`
+}
+
+// Reviews 表示产品评论信息
+type Reviews struct {
+	TotalReviews int     `json:"total_reviews"`
+	Rating       float64 `json:"rating"`
+}
+
+// Product 表示产品信息
+type Product struct {
+	Position     Position `json:"position"`
+	ASIN         string   `json:"asin"`
+	Price        Price    `json:"price"`
+	Reviews      Reviews  `json:"reviews"`
+	URL          string   `json:"url"`
+	Sponsored    bool     `json:"sponsored"`
+	AmazonChoice bool     `json:"amazon_choice"`
+	BestSeller   bool     `json:"best_seller"`
+	AmazonPrime  bool     `json:"amazon_prime"`
+	Title        string   `json:"title"`
+	Thumbnail    string   `json:"thumbnail"`
+	// Badges 卡片上的全部徽章，BoughtPastMonth 为"过去一个月购买量"的下限
+	Badges          []Badge `json:"badges"`
+	BoughtPastMonth int     `json:"bought_past_month"`
+}
+
+// Amazon域名映射
+var domains = map[string]string{
+	"US": "amazon.com",
+	"DE": "amazon.de",
+	"UK": "amazon.co.uk",
+	"CA": "amazon.ca",
+	"JP": "amazon.co.jp",
+	"FR": "amazon.fr",
+	"IT": "amazon.it",
+	"ES": "amazon.es",
+	"AU": "amazon.com.au",
+	"MX": "amazon.com.mx",
+}
+
+// Domain 根据code获取对应的Amazon域名
+func Domain(code string) string {
+	if domain, ok := domains[code]; ok {
+		return domain
+	}
+	return "amazon.com" // 默认返回美国站点
+}
+
+// Codes 返回支持的全部站点国家代码
+func Codes() []string {
+	codes := make([]string, 0, len(domains))
+	for code := range domains {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// IsCaptchaPage 判断页面是否为亚马逊验证码页面
+func IsCaptchaPage(html string) bool {
+	return strings.Contains(html, "/errors/validateCaptcha") ||
+		strings.Contains(html, "captchacharacters") ||
+		strings.Contains(html, "Type the characters you see in this image")
+}
+
+// AbsoluteURL 将页面中的相对链接补全为完整URL
+func AbsoluteURL(code string, href string) string {
+	domain := Domain(code)
+	if strings.HasPrefix(href, "/") {
+		return "https://www." + domain + href
+	} else if strings.HasPrefix(href, "http") {
+		return href
+	}
+	return "https://www." + domain + "/" + href
+}
+
+// usesDecimalComma 检查站点是否使用逗号作为小数点(DE、IT、FR、ES)，用于评论数和星级
+func usesDecimalComma(code string) bool {
+	return code == "DE" || code == "IT" || code == "FR" || code == "ES"
+}
+
+var nonNumericRe = regexp.MustCompile(`[^\d.]`)
+
+// parsePrice 将价格文本转换为数字
+func parsePrice(text string) float64 {
+	if text == "" {
+		return 0
+	}
+	price, _ := strconv.ParseFloat(nonNumericRe.ReplaceAllString(text, ""), 64)
+	return price
+}
+
+// numberSpaces 去掉数字中空格类的千位分隔符，FR等站点使用不换行空格(U+00A0)或窄不换行空格(U+202F)
+var numberSpaces = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "", "\u2009", "", "'", "")
+
+// parseCount 解析评论数等整数，去掉各站点的千位分隔符(",", ".", 空格类字符)，例如"1 234"、"1.234"、"1,234"
+func parseCount(text string) int {
+	text = reviewCountRe.FindString(text)
+	count, _ := strconv.Atoi(strings.NewReplacer(",", "", ".", "").Replace(numberSpaces.Replace(text)))
+	return count
+}
+
+// localizeNumber 去掉价格文本中的千位分隔符，并把小数点统一为"."。
+// 小数点按最后一个","或"."判断：后面跟1-2位数字时为小数点，否则为千位分隔符，JP站点没有小数
+func localizeNumber(code string, text string) string {
+	text = numberSpaces.Replace(text)
+	last := strings.LastIndexAny(text, ",.")
+	if last < 0 {
+		return text
+	}
+	digits := 0
+	for _, r := range text[last+1:] {
+		if r < '0' || r > '9' {
+			break
+		}
+		digits++
+	}
+	integer := strings.NewReplacer(",", "", ".", "").Replace(text[:last])
+	if digits == 0 || digits > 2 || code == "JP" {
+		return integer + text[last+1:]
+	}
+	return integer + "." + text[last+1:]
+}
@@ -0,0 +1,33 @@
+package parser
+
+import "testing"
+
+func TestLocalizeNumber(t *testing.T) {
+	tests := []struct {
+		code string
+		text string
+		want float64
+	}{
+		{"US", "$12.99", 12.99},
+		{"US", "$1,299.00", 1299},
+		{"UK", "£1,234", 1234},
+		{"DE", "12,99 €", 12.99},
+		{"DE", "1.299,00 €", 1299},
+		{"DE", "1.299 €", 1299},
+		{"IT", "1.234,5 €", 1234.5},
+		{"FR", "12,99 €", 12.99},
+		{"FR", "1 299,00 €", 1299},
+		{"FR", "1 299,99 €", 1299.99},
+		{"ES", "12,99 €", 12.99},
+		{"ES", "1.299,95 €", 1299.95},
+		{"MX", "$1,299.50", 1299.5},
+		{"JP", "￥1,299", 1299},
+		{"JP", "￥12,999", 12999},
+		{"DE", "", 0},
+	}
+	for _, tt := range tests {
+		if got := parsePrice(localizeNumber(tt.code, tt.text)); got != tt.want {
+			t.Errorf("parsePrice(localizeNumber(%s, %q)) = %v, want %v", tt.code, tt.text, got, tt.want)
+		}
+	}
+}
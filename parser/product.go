@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ProductPage 表示商品详情页的解析结果
+type ProductPage struct {
+	ASIN         string   `json:"asin"`
+	URL          string   `json:"url"`
+	Title        string   `json:"title"`
+	Brand        string   `json:"brand"`
+	Price        Price    `json:"price"`
+	Reviews      Reviews  `json:"reviews"`
+	Availability string   `json:"availability"`
+	BulletPoints []string `json:"bullet_points"`
+	Images       []string `json:"images"`
+	Badges       []Badge  `json:"badges"`
+}
+
+var (
+	numberRe = regexp.MustCompile(`[\d.,]+`)
+	// reviewCountRe 评论数，千位分隔符可能是",""."或空格
+	reviewCountRe = regexp.MustCompile(`\d[\d.,\x{00a0}\x{202f} ]*`)
+	asinInURLRe   = regexp.MustCompile(`/dp/([A-Z0-9]{10})`)
+)
+
+// ParseProduct 从HTML中解析商品详情页
+func ParseProduct(r io.Reader, opts Options) (*ProductPage, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("解析HTML失败: %v", err)
+	}
+
+	page := &ProductPage{
+		BulletPoints: []string{},
+		Images:       []string{},
+	}
+
+	// ASIN优先取隐藏表单字段，其次取canonical链接
+	page.ASIN, _ = doc.Find("input#ASIN, input[name='ASIN']").First().Attr("value")
+	canonical, _ := doc.Find("link[rel='canonical']").Attr("href")
+	if page.ASIN == "" {
+		if matches := asinInURLRe.FindStringSubmatch(canonical); len(matches) > 1 {
+			page.ASIN = matches[1]
+		}
+	}
+	if page.ASIN != "" {
+		page.URL = fmt.Sprintf("https://www.%s/dp/%s", Domain(opts.Code), page.ASIN)
+	}
+
+	page.Title = cleanText(doc.Find("#productTitle").Text())
+	page.Brand = cleanText(doc.Find("#bylineInfo").Text())
+	page.Availability = cleanText(doc.Find("#availability").Text())
+
+	// 价格
+	eleCorePrice := doc.Find("#corePrice_feature_div, #corePriceDisplay_desktop_feature_div, #apex_desktop").First()
+	currentPriceText := eleCorePrice.Find(".a-price .a-offscreen").First().Text()
+	beforePriceText := eleCorePrice.Find(".a-price.a-text-price .a-offscreen").First().Text()
+	page.Price = Price{
+		Discounted:   beforePriceText != "",
+		CurrentPrice: parsePrice(localizeNumber(opts.Code, currentPriceText)),
+		BeforePrice:  parsePrice(localizeNumber(opts.Code, beforePriceText)),
+	}
+
+	// 评论数和星级
+	page.Reviews.TotalReviews = parseCount(doc.Find("#acrCustomerReviewText").First().Text())
+
+	starText, _ := doc.Find("#acrPopover").Attr("title")
+	if starText == "" {
+		starText = doc.Find("#acrPopover .a-icon-alt").First().Text()
+	}
+	if usesDecimalComma(opts.Code) || strings.Contains(starText, ",") {
+		starText = strings.ReplaceAll(starText, ",", ".")
+	}
+	// 日文站点格式为"5つ星のうち4.5"，星级在最后
+	starNumbers := numberRe.FindAllString(starText, -1)
+	if len(starNumbers) > 0 {
+		starNumber := starNumbers[0]
+		if opts.Code == "JP" {
+			starNumber = starNumbers[len(starNumbers)-1]
+		}
+		page.Reviews.Rating, _ = strconv.ParseFloat(strings.Trim(starNumber, "."), 64)
+	}
+
+	doc.Find("#feature-bullets li span.a-list-item").Each(func(_ int, s *goquery.Selection) {
+		if text := cleanText(s.Text()); text != "" {
+			page.BulletPoints = append(page.BulletPoints, text)
+		}
+	})
+
+	doc.Find("#altImages img, #landingImage").Each(func(_ int, s *goquery.Selection) {
+		src, ok := s.Attr("data-old-hires")
+		if !ok || src == "" {
+			src, _ = s.Attr("src")
+		}
+		if src != "" && !strings.Contains(src, "sprite") {
+			page.Images = append(page.Images, src)
+		}
+	})
+
+	page.Badges = ExtractBadges(doc.Find("#centerCol, #ppd").First(), page.ASIN)
+
+	return page, nil
+}
+
+// cleanText 合并文本中的连续空白
+func cleanText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
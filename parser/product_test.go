@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openFixture(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestParseProductFR(t *testing.T) {
+	page, err := ParseProduct(openFixture(t, "product_fr.html"), Options{Code: "FR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.ASIN != "B0FR000001" {
+		t.Errorf("ASIN = %q", page.ASIN)
+	}
+	if page.Price.CurrentPrice != 1299.99 || page.Price.BeforePrice != 1499 || !page.Price.Discounted {
+		t.Errorf("price = %+v", page.Price)
+	}
+	if page.Reviews.TotalReviews != 1234 {
+		t.Errorf("total reviews = %d, want 1234", page.Reviews.TotalReviews)
+	}
+	if page.Reviews.Rating != 4.5 {
+		t.Errorf("rating = %v, want 4.5", page.Reviews.Rating)
+	}
+}
+
+func TestParseSearchPricesES(t *testing.T) {
+	page, err := ParseSearch(openFixture(t, "search_prices_es.html"), Options{Code: "ES", Page: 1, Query: "auriculares"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Products) != 2 {
+		t.Fatalf("products = %d, want 2", len(page.Products))
+	}
+	if got := page.Products[0].Price; got.CurrentPrice != 12.99 || got.BeforePrice != 15.99 {
+		t.Errorf("first price = %+v", got)
+	}
+	if got := page.Products[1].Price.CurrentPrice; got != 1299 {
+		t.Errorf("second price = %v, want 1299", got)
+	}
+}
+
+func TestParseSearchReviewsFR(t *testing.T) {
+	page, err := ParseSearch(openFixture(t, "search_reviews_fr.html"), Options{Code: "FR", Page: 1, Query: "casque"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Products) != 2 {
+		t.Fatalf("products = %d, want 2", len(page.Products))
+	}
+	// 千位分隔符为不换行空格和窄不换行空格
+	if got := page.Products[0].Reviews.TotalReviews; got != 1234 {
+		t.Errorf("first total reviews = %d, want 1234", got)
+	}
+	if got := page.Products[1].Reviews.TotalReviews; got != 12345 {
+		t.Errorf("second total reviews = %d, want 12345", got)
+	}
+	if got := page.Products[0].Price.CurrentPrice; got != 1299 {
+		t.Errorf("first price = %v, want 1299", got)
+	}
+}
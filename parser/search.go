@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SearchPage 表示一个搜索结果页的解析结果
+type SearchPage struct {
+	Page         int           `json:"page"`
+	Products     []Product     `json:"products"`
+	TotalResults string        `json:"total_results,omitempty"`
+	Outcome      SearchOutcome `json:"outcome"`
+	NextPageURL  string        `json:"next_page_url,omitempty"`
+	HasNextPage  bool          `json:"has_next_page"`
+}
+
+var totalResultCountRe = regexp.MustCompile(`"totalResultCount":(\w+.[0-9])`)
+
+// ParseSearch 从HTML中解析搜索结果页
+func ParseSearch(r io.Reader, opts Options) (*SearchPage, error) {
+	html, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("读取HTML失败: %v", err)
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, fmt.Errorf("解析HTML失败: %v", err)
+	}
+	if opts.Page <= 0 {
+		opts.Page = 1
+	}
+
+	result := &SearchPage{
+		Page:     opts.Page,
+		Products: ScrapePageProds(doc, opts.Page, opts.Code),
+		Outcome:  DetectSearchOutcome(doc, opts.Query),
+	}
+
+	// 提取总结果数
+	if matches := totalResultCountRe.FindSubmatch(html); len(matches) > 1 {
+		result.TotalResults = string(matches[1])
+	}
+
+	// 从页面中提取下一页链接
+	nextPageLink := doc.Find(".s-pagination-item.s-pagination-next:not(.s-pagination-disabled)")
+	if nextPageLink.Length() > 0 {
+		result.HasNextPage = true
+		if href, exists := nextPageLink.Attr("href"); exists {
+			result.NextPageURL = AbsoluteURL(opts.Code, href)
+		}
+	}
+
+	return result, nil
+}
+
+// ScrapePageProds 解析页面中的产品信息
+func ScrapePageProds(doc *goquery.Document, page int, code string) []Product {
+	prodList := []Product{}
+
+	try := func() {
+		eleSearchResults := doc.Find(".s-search-results [data-component-type=\"s-search-result\"]")
+		prodsCount := eleSearchResults.Length()
+		globalPosition := prodsCount * (page - 1)
+
+		eleSearchResults.Each(func(idx int, item *goquery.Selection) {
+			prodItem := Product{}
+
+			// 解析价格
+			elePrice := item.Find("span[data-a-size=\"xl\"]").First()
+			if elePrice.Length() == 0 {
+				elePrice = item.Find("span[data-a-size=\"l\"]").First()
+			}
+			if elePrice.Length() == 0 {
+				elePrice = item.Find("span[data-a-size=\"m\"]").First()
+			}
+
+			eleDiscounted := item.Find("span.a-price.a-text-price")
+			currentPriceText := priceText(elePrice)
+			discountPriceText := priceText(eleDiscounted)
+
+			// 解析产品链接
+			eleProdLink := item.Find("span[data-component-type=\"s-product-image\"] a")
+			productURL := ""
+			if eleProdLink.Length() > 0 {
+				productURL, _ = eleProdLink.Attr("href")
+			}
+
+			// 解析评论
+			eleReviews := item.Find("[data-csa-c-slot-id=\"alf-reviews\"] a")
+			reviewsText := ""
+			if eleReviews.Length() > 0 {
+				reviewsText, _ = eleReviews.Attr("aria-label")
+			}
+
+			// 解析星级
+			eleStar := item.Find("a.mvt-review-star-mini-popover,.a-icon-star-small")
+			starText := ""
+			if eleStar.Length() > 0 {
+				starText, _ = eleStar.Attr("aria-label")
+			}
+
+			// 按站点格式处理千位分隔符和小数点
+			currentPriceText = localizeNumber(code, currentPriceText)
+			discountPriceText = localizeNumber(code, discountPriceText)
+			if usesDecimalComma(code) {
+				starText = strings.ReplaceAll(starText, ",", ".")
+			}
+
+			// 设置位置信息
+			prodItem.Position = Position{
+				Page:           page,
+				Position:       idx + 1,
+				GlobalPosition: globalPosition + idx + 1,
+			}
+
+			// 设置ASIN
+			prodItem.ASIN, _ = item.Attr("data-asin")
+
+			// 设置价格信息
+			prodItem.Price = Price{
+				Discounted:   eleDiscounted.Length() > 0,
+				CurrentPrice: parsePrice(currentPriceText),
+				BeforePrice:  parsePrice(discountPriceText),
+			}
+
+			// 设置评论信息
+			totalReviews := parseCount(reviewsText)
+
+			rating := 0.0
+			if starText != "" {
+				rating, _ = strconv.ParseFloat(starText, 64)
+			}
+
+			prodItem.Reviews = Reviews{
+				TotalReviews: totalReviews,
+				Rating:       rating,
+			}
+
+			// 设置URL
+			if productURL != "" {
+				prodItem.URL = AbsoluteURL(code, productURL)
+			} else {
+				prodItem.URL = fmt.Sprintf("https://www.%s/dp/%s", Domain(code), prodItem.ASIN)
+			}
+
+			// 设置其他属性
+			prodItem.Sponsored = item.Find("span.puis-sponsored-label-info-icon").Length() > 0 || strings.Contains(prodItem.URL, "/sspa/")
+			prodItem.Badges = ExtractBadges(item, prodItem.ASIN)
+			prodItem.AmazonChoice = HasBadge(prodItem.Badges, BadgeAmazonChoice)
+			prodItem.BestSeller = HasBadge(prodItem.Badges, BadgeBestSeller)
+			prodItem.AmazonPrime = HasBadge(prodItem.Badges, BadgeAmazonPrime)
+			for _, badge := range prodItem.Badges {
+				if badge.Type == BadgeBoughtPastMonth {
+					prodItem.BoughtPastMonth = ParseBoughtPastMonth(badge.Label)
+				}
+			}
+
+			// 设置标题
+			eleTitle := item.Find("[data-cy=\"title-recipe\"] span.a-text-normal")
+			if eleTitle.Length() == 0 {
+				eleTitle = item.Find("[data-cy=\"title-recipe\"] h2.a-size-base-plus span")
+			}
+			if eleTitle.Length() == 0 {
+				eleTitle = item.Find("[data-cy=\"title-recipe\"] h2.a-size-medium span")
+			}
+			if eleTitle.Length() > 0 {
+				prodItem.Title = eleTitle.Text()
+			}
+
+			// 设置缩略图
+			eleThumbnail := item.Find("img[data-image-source-density=\"1\"]")
+			if eleThumbnail.Length() > 0 {
+				prodItem.Thumbnail, _ = eleThumbnail.Attr("src")
+			}
+
+			prodList = append(prodList, prodItem)
+		})
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] <scrape_page_prods> %v", r)
+		}
+	}()
+
+	try()
+
+	return prodList
+}
+
+// priceText 返回价格元素的文本，优先取屏幕阅读器使用的完整价格(.a-offscreen)，
+// 避免把拆开显示的整数、小数和货币符号重复拼接
+func priceText(price *goquery.Selection) string {
+	if price.Length() == 0 {
+		return ""
+	}
+	if offscreen := price.Find(".a-offscreen").First(); offscreen.Length() > 0 {
+		return strings.TrimSpace(offscreen.Text())
+	}
+	return price.Find("span").Text()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Marketplace 表示站点的语言和货币
+type Marketplace struct {
+	// AcceptLanguage 浏览器在该站点发送的Accept-Language
+	AcceptLanguage string
+	// Language 语言偏好Cookie的值，例如de_DE
+	Language string
+	// LanguageCookie 保存语言偏好的Cookie名称，美国站为lc-main，其他站点为lc-acb加站点代码
+	LanguageCookie string
+	// Currency 货币偏好Cookie(i18n-prefs)的值
+	Currency string
+}
+
+// 各站点的语言和货币
+var marketplaces = map[string]Marketplace{
+	"US": {AcceptLanguage: "en-US,en;q=0.9", Language: "en_US", LanguageCookie: "lc-main", Currency: "USD"},
+	"DE": {AcceptLanguage: "de-DE,de;q=0.9,en;q=0.8", Language: "de_DE", LanguageCookie: "lc-acbde", Currency: "EUR"},
+	"UK": {AcceptLanguage: "en-GB,en;q=0.9", Language: "en_GB", LanguageCookie: "lc-acbuk", Currency: "GBP"},
+	"CA": {AcceptLanguage: "en-CA,en;q=0.9,fr-CA;q=0.8", Language: "en_CA", LanguageCookie: "lc-acbca", Currency: "CAD"},
+	"JP": {AcceptLanguage: "ja-JP,ja;q=0.9,en;q=0.8", Language: "ja_JP", LanguageCookie: "lc-acbjp", Currency: "JPY"},
+	"FR": {AcceptLanguage: "fr-FR,fr;q=0.9,en;q=0.8", Language: "fr_FR", LanguageCookie: "lc-acbfr", Currency: "EUR"},
+	"IT": {AcceptLanguage: "it-IT,it;q=0.9,en;q=0.8", Language: "it_IT", LanguageCookie: "lc-acbit", Currency: "EUR"},
+	"ES": {AcceptLanguage: "es-ES,es;q=0.9,en;q=0.8", Language: "es_ES", LanguageCookie: "lc-acbes", Currency: "EUR"},
+	"AU": {AcceptLanguage: "en-AU,en;q=0.9", Language: "en_AU", LanguageCookie: "lc-acbau", Currency: "AUD"},
+	"MX": {AcceptLanguage: "es-MX,es;q=0.9,en;q=0.8", Language: "es_MX", LanguageCookie: "lc-acbmx", Currency: "MXN"},
+}
+
+// browser 表示一种浏览器的请求头，同一浏览器的User-Agent和Client Hints保持一致
+type browser struct {
+	Name      string
+	UserAgent string
+	// ClientHints Chromium内核浏览器发送的sec-ch-ua系列请求头，Firefox和Safari不发送
+	ClientHints map[string]string
+}
+
+var browsers = []browser{
+	{
+		Name:      "chrome-win",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		ClientHints: map[string]string{
+			"sec-ch-ua":          `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"Windows"`,
+		},
+	},
+	{
+		Name:      "chrome-mac",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		ClientHints: map[string]string{
+			"sec-ch-ua":          `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"macOS"`,
+		},
+	},
+	{
+		Name:      "edge-win",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+		ClientHints: map[string]string{
+			"sec-ch-ua":          `"Not_A Brand";v="8", "Chromium";v="120", "Microsoft Edge";v="120"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"Windows"`,
+		},
+	},
+	{
+		Name:      "firefox-win",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	},
+	{
+		Name:      "safari-mac",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+	},
+}
+
+// HeaderProfile 表示一个会话使用的请求头，由站点的语言、货币和一种浏览器组成，
+// 同一会话的全部请求使用同一个Profile，保证翻页时内容的语言和货币一致
+type HeaderProfile struct {
+	// Name 浏览器名称，保存在会话中，复用会话时据此恢复Profile
+	Name string
+	Code string
+	Marketplace
+	browser browser
+}
+
+// NewHeaderProfile 为站点创建Profile，设置了HEADER_PROFILE时使用该浏览器(便于响应缓存命中)，否则随机选择
+func NewHeaderProfile(code string) *HeaderProfile {
+	if name := os.Getenv("HEADER_PROFILE"); name != "" {
+		return HeaderProfileByName(code, name)
+	}
+	return headerProfile(code, browsers[rand.Intn(len(browsers))])
+}
+
+// HeaderProfileByName 按浏览器名称创建Profile，名称未知时随机选择
+func HeaderProfileByName(code string, name string) *HeaderProfile {
+	for _, b := range browsers {
+		if b.Name == name {
+			return headerProfile(code, b)
+		}
+	}
+	return headerProfile(code, browsers[rand.Intn(len(browsers))])
+}
+
+func headerProfile(code string, b browser) *HeaderProfile {
+	marketplace, ok := marketplaces[code]
+	if !ok {
+		marketplace = marketplaces["US"]
+	}
+	return &HeaderProfile{Name: b.Name, Code: code, Marketplace: marketplace, browser: b}
+}
+
+// Headers 返回页面请求的请求头。Accept-Encoding只声明gzip，因为客户端只能解压gzip
+func (p *HeaderProfile) Headers() map[string]string {
+	headers := map[string]string{
+		"User-Agent":                p.browser.UserAgent,
+		"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+		"Accept-Language":           p.AcceptLanguage,
+		"Accept-Encoding":           "gzip",
+		"Upgrade-Insecure-Requests": "1",
+	}
+	for name, value := range p.browser.ClientHints {
+		headers[name] = value
+	}
+	return headers
+}
+
+// Cookies 返回站点的语言和货币偏好Cookie
+func (p *HeaderProfile) Cookies() []*http.Cookie {
+	return []*http.Cookie{
+		{Name: "i18n-prefs", Value: p.Currency},
+		{Name: p.LanguageCookie, Value: p.Language},
+	}
+}
+
+// Apply 将语言和货币偏好Cookie写入客户端，客户端没有Cookie Jar时直接设置在客户端上
+func (p *HeaderProfile) Apply(client *resty.Client) {
+	u, err := url.Parse(fmt.Sprintf("https://www.%s/", GetAmazonDomain(p.Code)))
+	if err != nil {
+		return
+	}
+	if jar := client.GetClient().Jar; jar != nil {
+		jar.SetCookies(u, p.Cookies())
+		return
+	}
+	client.SetCookies(p.Cookies())
+}
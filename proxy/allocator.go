@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/redis/go-redis/v9"
+
+	"awesomeProject/db"
+)
+
+// BudgetLimiter 由限制节点请求频率的代理提供者实现，每次请求前调用Consume，返回false表示节点本分钟的请求预算已用完
+type BudgetLimiter interface {
+	Consume(endpoint *Endpoint) bool
+}
+
+// acquireScript 按最近最少使用的顺序检查候选节点，跳过并发租约已满或本分钟请求数已达上限的节点，
+// 租约保存在有序集合中，分数为过期时间
+var acquireScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local maxWorkers = tonumber(ARGV[3])
+local rpm = tonumber(ARGV[4])
+local minute = ARGV[5]
+local id = ARGV[6]
+local prefix = ARGV[7]
+
+local nodes = {}
+for i = 8, #ARGV do
+	local lastUsed = redis.call('ZSCORE', prefix .. 'lru', ARGV[i])
+	table.insert(nodes, {ARGV[i], tonumber(lastUsed) or 0})
+end
+table.sort(nodes, function(a, b) return a[2] < b[2] end)
+
+for _, n in ipairs(nodes) do
+	local leaseKey = prefix .. 'lease:' .. n[1]
+	redis.call('ZREMRANGEBYSCORE', leaseKey, '-inf', now)
+	local workers = redis.call('ZCARD', leaseKey)
+	local requests = tonumber(redis.call('GET', prefix .. 'rpm:' .. n[1] .. ':' .. minute) or '0')
+	if (maxWorkers <= 0 or workers < maxWorkers) and (rpm <= 0 or requests < rpm) then
+		redis.call('ZADD', leaseKey, now + ttl, id)
+		redis.call('PEXPIRE', leaseKey, ttl)
+		redis.call('ZADD', prefix .. 'lru', now, n[1])
+		return n[1]
+	end
+end
+return false
+`)
+
+// renewScript 续期租约，租约已过期被清理时在节点并发未满的情况下重新占用，
+// 返回1表示续期成功，2表示重新占用，0表示节点并发已满
+var renewScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local id = ARGV[3]
+local maxWorkers = tonumber(ARGV[4])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+local result = 1
+if not redis.call('ZSCORE', key, id) then
+	if maxWorkers > 0 and redis.call('ZCARD', key) >= maxWorkers then
+		return 0
+	end
+	result = 2
+end
+redis.call('ZADD', key, now + ttl, id)
+redis.call('PEXPIRE', key, ttl)
+return result
+`)
+
+// Allocator 通过Redis在多个容器之间分配代理节点：工作协程以带TTL的租约占用节点，
+// 限制每个节点的并发工作协程数和每分钟请求数，并优先分配最近最少使用的节点
+type Allocator struct {
+	client *redis.Client
+	prefix string
+
+	ttl        time.Duration
+	maxWorkers int
+	rpm        int
+
+	mu     sync.Mutex
+	leases map[string]string
+	stop   chan struct{}
+}
+
+// NewAllocator 创建节点分配器，参数来自环境变量PROXY_LEASE_TTL、PROXY_NODE_MAX_WORKERS和PROXY_NODE_RPM
+func NewAllocator(client *redis.Client) *Allocator {
+	a := &Allocator{
+		client:     client,
+		prefix:     "amazon:proxy_alloc:",
+		ttl:        envDuration("PROXY_LEASE_TTL", 2*time.Minute),
+		maxWorkers: envLimit("PROXY_NODE_MAX_WORKERS", 2),
+		rpm:        envLimit("PROXY_NODE_RPM", 30),
+		leases:     make(map[string]string),
+		stop:       make(chan struct{}),
+	}
+	go a.renewLoop()
+	return a
+}
+
+// NewRedisAllocator 使用configs表中的Redis配置创建节点分配器
+func NewRedisAllocator() (*Allocator, error) {
+	postgresDB, err := db.NewPostgresDB()
+	if err != nil {
+		return nil, fmt.Errorf("创建数据库连接失败: %v", err)
+	}
+	defer postgresDB.Close()
+	client, err := postgresDB.NewRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	return NewAllocator(client), nil
+}
+
+// Acquire 从候选节点中租用一个节点，返回节点名称和租约ID，没有可用节点时返回错误
+func (a *Allocator) Acquire(ctx context.Context, candidates []string) (string, string, error) {
+	if len(candidates) == 0 {
+		return "", "", fmt.Errorf("没有可用的代理节点")
+	}
+	leaseID := newSessionID()
+	now := time.Now()
+	args := []interface{}{
+		now.UnixMilli(), a.ttl.Milliseconds(), a.maxWorkers, a.rpm, minuteKey(now), leaseID, a.prefix,
+	}
+	for _, node := range candidates {
+		args = append(args, node)
+	}
+	node, err := acquireScript.Run(ctx, a.client, nil, args...).Text()
+	if errors.Is(err, redis.Nil) {
+		return "", "", fmt.Errorf("所有代理节点的并发或请求数已达上限")
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("租用代理节点失败: %v", err)
+	}
+
+	a.mu.Lock()
+	a.leases[leaseID] = node
+	a.mu.Unlock()
+	return node, leaseID, nil
+}
+
+// Release 归还节点租约
+func (a *Allocator) Release(node string, leaseID string) {
+	if leaseID == "" {
+		return
+	}
+	a.mu.Lock()
+	delete(a.leases, leaseID)
+	a.mu.Unlock()
+	if err := a.client.ZRem(context.Background(), a.leaseKey(node), leaseID).Err(); err != nil {
+		logs.Warn("归还代理节点租约失败:", err)
+	}
+}
+
+// Consume 记录节点的一次请求，本分钟请求数超过预算时返回false
+func (a *Allocator) Consume(node string) bool {
+	if a.rpm <= 0 {
+		return true
+	}
+	ctx := context.Background()
+	key := a.prefix + "rpm:" + node + ":" + minuteKey(time.Now())
+	pipe := a.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 2*time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		// Redis不可用时不阻塞抓取
+		logs.Warn("记录节点请求数失败:", err)
+		return true
+	}
+	return incr.Val() <= int64(a.rpm)
+}
+
+// Close 停止续约并归还全部租约
+func (a *Allocator) Close() {
+	close(a.stop)
+	a.mu.Lock()
+	leases := make(map[string]string, len(a.leases))
+	for id, node := range a.leases {
+		leases[id] = node
+	}
+	a.mu.Unlock()
+	for id, node := range leases {
+		a.Release(node, id)
+	}
+}
+
+// renewLoop 定时为仍在使用的租约续期，进程退出后租约自然过期
+func (a *Allocator) renewLoop() {
+	ticker := time.NewTicker(a.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		leases := make(map[string]string, len(a.leases))
+		for id, node := range a.leases {
+			leases[id] = node
+		}
+		a.mu.Unlock()
+
+		for id, node := range leases {
+			a.renew(node, id)
+		}
+	}
+}
+
+// renew 续期一个租约。租约因续期不及时(例如Redis暂时不可用)已过期时重新占用，
+// 节点并发已满无法重新占用时只记录日志，端口继续使用该节点直到下次切换
+func (a *Allocator) renew(node string, leaseID string) {
+	result, err := renewScript.Run(context.Background(), a.client, []string{a.leaseKey(node)},
+		time.Now().UnixMilli(), a.ttl.Milliseconds(), leaseID, a.maxWorkers).Int()
+	switch {
+	case err != nil:
+		logs.Warn("代理节点租约续期失败:", err)
+	case result == 2:
+		logs.Warn(fmt.Sprintf("节点%s的租约已过期，已重新占用", node))
+	case result == 0:
+		logs.Err(fmt.Sprintf("节点%s的租约已过期且并发已满，无法重新占用", node))
+	}
+}
+
+func (a *Allocator) leaseKey(node string) string {
+	return a.prefix + "lease:" + node
+}
+
+// minuteKey 返回当前分钟，用于每分钟请求数的计数键
+func minuteKey(t time.Time) string {
+	return strconv.FormatInt(t.Unix()/60, 10)
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/parser"
+)
+
+// 站点访问状态
+const (
+	StatusOK      = "ok"
+	StatusBlocked = "503"
+	StatusCaptcha = "CAPTCHA"
+	StatusTimeout = "timeout"
+	StatusError   = "error"
+)
+
+// NodeReport 表示一个节点的诊断结果
+type NodeReport struct {
+	Node    string
+	ExitIP  string
+	Country string
+	// Latency 访问第一个站点的延迟，测速失败时为0
+	Latency time.Duration
+	// Status 各站点国家代码对应的访问状态
+	Status map[string]string
+	Error  string
+}
+
+// Usable 判断节点访问指定站点是否正常
+func (r NodeReport) Usable(code string) bool {
+	return r.Status[code] == StatusOK
+}
+
+// Diagnose 逐个节点获取出口IP和国家，并测试访问各站点首页的状态
+func (c *Clash) Diagnose(codes []string, geo *GeoResolver) []NodeReport {
+	names := c.NodeNames()
+	sort.Strings(names)
+	reports := make([]NodeReport, len(names))
+
+	sem := make(chan struct{}, 10)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			reports[i] = c.diagnoseNode(name, codes, geo)
+		}(i, name)
+	}
+	wg.Wait()
+	return reports
+}
+
+func (c *Clash) diagnoseNode(name string, codes []string, geo *GeoResolver) NodeReport {
+	report := NodeReport{Node: name, Status: make(map[string]string)}
+	if geo != nil {
+		ip, country, err := geo.LookupExit(name)
+		if err != nil {
+			report.Error = err.Error()
+		}
+		if ip != nil {
+			report.ExitIP = ip.String()
+		}
+		if country == "" {
+			// 出口IP查不到时按节点名称判断
+			country = geo.Country(name)
+		}
+		report.Country = country
+	}
+	if len(codes) > 0 {
+		if latency, err := c.Delay(name, marketplaceURL(codes[0]), 5*time.Second); err == nil {
+			report.Latency = latency
+		}
+	}
+	for _, code := range codes {
+		report.Status[code] = marketplaceStatus(name, code)
+	}
+	return report
+}
+
+// marketplaceStatus 通过节点请求站点首页，返回访问状态
+func marketplaceStatus(node string, code string) string {
+	client, err := nodeHTTPClient(node, 15*time.Second)
+	if err != nil {
+		return StatusError
+	}
+	req, err := http.NewRequest(http.MethodGet, marketplaceURL(code), nil)
+	if err != nil {
+		return StatusError
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	resp, err := client.Do(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "Timeout") || strings.Contains(err.Error(), "timeout") {
+			return StatusTimeout
+		}
+		return StatusError
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return StatusBlocked
+	case parser.IsCaptchaPage(string(body)):
+		return StatusCaptcha
+	case resp.StatusCode == http.StatusOK:
+		return StatusOK
+	default:
+		return fmt.Sprintf("%d", resp.StatusCode)
+	}
+}
+
+// marketplaceURL 返回站点首页地址
+func marketplaceURL(code string) string {
+	return "https://www." + parser.Domain(code) + "/"
+}
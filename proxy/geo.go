@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/moshaoli688/clash/component/mmdb"
+	C "github.com/moshaoli688/clash/constant"
+	"github.com/moshaoli688/clash/tunnel"
+
+	"awesomeProject/db"
+)
+
+// 没有与任务国家匹配的节点时的处理方式
+const (
+	// GeoFallbackAny 使用任意可用节点
+	GeoFallbackAny = "any"
+	// GeoFallbackRegion 使用同一地区的节点，例如德国站使用其他欧洲节点，仍没有时使用任意节点
+	GeoFallbackRegion = "region"
+	// GeoFallbackStrict 不使用其他国家的节点，直接返回错误
+	GeoFallbackStrict = "strict"
+)
+
+// defaultGeoPatterns 常见订阅的节点命名方式，键为任务国家代码
+var defaultGeoPatterns = map[string][]string{
+	"US": {`🇺🇸`, `美国`, `(?i)united\s*states`, `\bUSA?\b`},
+	"UK": {`🇬🇧`, `英国`, `(?i)united\s*kingdom`, `\b(UK|GB)\b`},
+	"DE": {`🇩🇪`, `德国`, `(?i)germany`, `\bDE\b`},
+	"FR": {`🇫🇷`, `法国`, `(?i)france`, `\bFR\b`},
+	"IT": {`🇮🇹`, `意大利`, `(?i)italy`, `\bIT\b`},
+	"ES": {`🇪🇸`, `西班牙`, `(?i)spain`, `\bES\b`},
+	"CA": {`🇨🇦`, `加拿大`, `(?i)canada`, `\bCA\b`},
+	"MX": {`🇲🇽`, `墨西哥`, `(?i)mexico`, `\bMX\b`},
+	"JP": {`🇯🇵`, `日本`, `(?i)japan`, `\bJP\b`},
+	"AU": {`🇦🇺`, `澳大利亚`, `澳洲`, `(?i)australia`, `\bAU\b`},
+}
+
+// geoRegions 国家所属地区，用于GeoFallbackRegion
+var geoRegions = map[string]string{
+	"US": "NA", "CA": "NA", "MX": "NA",
+	"UK": "EU", "DE": "EU", "FR": "EU", "IT": "EU", "ES": "EU",
+	"NL": "EU", "BE": "EU", "AT": "EU", "CH": "EU", "PL": "EU", "IE": "EU", "SE": "EU",
+	"JP": "APAC", "AU": "APAC", "SG": "APAC", "KR": "APAC", "HK": "APAC", "TW": "APAC",
+}
+
+// geoRule 一条节点名称规则
+type geoRule struct {
+	code string
+	re   *regexp.Regexp
+}
+
+// GeoResolver 判断节点出口所在国家：优先按节点名称匹配，匹配不到时使用离线GeoIP数据库查询出口IP
+type GeoResolver struct {
+	// rules 按匹配顺序排列的节点名称规则
+	rules    []geoRule
+	fallback string
+	ipURL    string
+
+	mu    sync.RWMutex
+	exits map[string]string
+}
+
+// NewGeoResolver 创建节点地理位置解析器，patterns的键为任务国家代码，值为匹配节点名称的正则表达式
+func NewGeoResolver(patterns map[string][]string, fallback string) (*GeoResolver, error) {
+	g := &GeoResolver{
+		fallback: strings.ToLower(fallback),
+		ipURL:    os.Getenv("PROXY_GEO_IP_URL"),
+		exits:    make(map[string]string),
+	}
+	switch g.fallback {
+	case "":
+		g.fallback = GeoFallbackAny
+	case GeoFallbackAny, GeoFallbackRegion, GeoFallbackStrict:
+	default:
+		return nil, fmt.Errorf("不支持的节点国家回退策略: %s", fallback)
+	}
+	if g.ipURL == "" {
+		g.ipURL = "https://api.ipify.org"
+	}
+	// 每个国家靠前的规则更具体(国旗、中文名)，先按规则序号再按国家代码排列，
+	// 节点名称同时匹配多个国家时结果固定，且国旗优先于US、DE之类的缩写
+	codes := make([]string, 0, len(patterns))
+	for code := range patterns {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for i := 0; ; i++ {
+		added := false
+		for _, code := range codes {
+			exprs := patterns[code]
+			if i >= len(exprs) {
+				continue
+			}
+			re, err := regexp.Compile(exprs[i])
+			if err != nil {
+				return nil, fmt.Errorf("节点名称规则%s错误: %v", exprs[i], err)
+			}
+			g.rules = append(g.rules, geoRule{code: strings.ToUpper(code), re: re})
+			added = true
+		}
+		if !added {
+			break
+		}
+	}
+	return g, nil
+}
+
+// LoadGeoResolver 读取configs表中type="node_geo"的节点命名规则，没有配置时使用内置规则，
+// 回退策略来自环境变量PROXY_GEO_FALLBACK
+func LoadGeoResolver() (*GeoResolver, error) {
+	patterns := defaultGeoPatterns
+	postgresDB, err := db.NewPostgresDB()
+	if err == nil {
+		defer postgresDB.Close()
+		if values, err := postgresDB.GetNodeGeoConfig(); err == nil {
+			configured := make(map[string][]string)
+			if err := json.Unmarshal([]byte(values), &configured); err != nil {
+				return nil, fmt.Errorf("解析节点命名规则失败: %v", err)
+			}
+			patterns = configured
+		}
+	}
+	return NewGeoResolver(patterns, os.Getenv("PROXY_GEO_FALLBACK"))
+}
+
+// Country 返回节点所在国家代码，无法判断时返回空字符串
+func (g *GeoResolver) Country(node string) string {
+	for _, rule := range g.rules {
+		if rule.re.MatchString(node) {
+			return rule.code
+		}
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.exits[node]
+}
+
+// Filter 返回与国家代码匹配的节点，没有匹配时按回退策略处理
+func (g *GeoResolver) Filter(nodes []string, country string) ([]string, error) {
+	country = strings.ToUpper(country)
+	if country == "" || len(nodes) == 0 {
+		return nodes, nil
+	}
+	matched := make([]string, 0)
+	for _, node := range nodes {
+		if g.Country(node) == country {
+			matched = append(matched, node)
+		}
+	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+
+	switch g.fallback {
+	case GeoFallbackStrict:
+		return nil, fmt.Errorf("没有%s的代理节点", country)
+	case GeoFallbackRegion:
+		if region, ok := geoRegions[country]; ok {
+			for _, node := range nodes {
+				if geoRegions[g.Country(node)] == region {
+					matched = append(matched, node)
+				}
+			}
+		}
+		if len(matched) > 0 {
+			logs.Warn(fmt.Sprintf("没有%s的代理节点，使用同地区节点", country))
+			return matched, nil
+		}
+	}
+	logs.Warn(fmt.Sprintf("没有%s的代理节点，使用任意节点", country))
+	return nodes, nil
+}
+
+// ResolveExits 对名称无法判断国家的节点，通过节点请求出口IP并用GeoIP数据库查询国家
+func (g *GeoResolver) ResolveExits(nodes []string) {
+	sem := make(chan struct{}, 10)
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		if g.Country(node) != "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(node string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, code, err := g.LookupExit(node)
+			if err != nil {
+				logs.Warn(fmt.Sprintf("获取节点%s出口IP失败: %v", node, err))
+				return
+			}
+			if code == "" {
+				return
+			}
+			g.mu.Lock()
+			g.exits[node] = code
+			g.mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+}
+
+// LookupExit 通过节点获取出口IP，并用GeoIP数据库查询所在国家代码，查询不到国家时返回空字符串
+func (g *GeoResolver) LookupExit(node string) (net.IP, string, error) {
+	ip, err := g.exitIP(node)
+	if err != nil {
+		return nil, "", err
+	}
+	record, err := mmdb.Instance().Country(ip)
+	if err != nil {
+		return ip, "", nil
+	}
+	code := record.Country.IsoCode
+	if code == "GB" {
+		// 任务国家代码使用UK
+		code = "UK"
+	}
+	return ip, code, nil
+}
+
+// nodeHTTPClient 创建直接通过指定节点发出请求的HTTP客户端，不经过本地监听端口和规则
+func nodeHTTPClient(node string, timeout time.Duration) (*http.Client, error) {
+	p, ok := tunnel.Proxies()[node]
+	if !ok {
+		return nil, fmt.Errorf("节点不存在")
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				host, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				portNum, _ := strconv.ParseUint(port, 10, 16)
+				return p.DialContext(ctx, &C.Metadata{Host: host, DstPort: C.Port(portNum)})
+			},
+		},
+	}, nil
+}
+
+// exitIP 通过节点请求IP查询服务，返回节点的出口IP
+func (g *GeoResolver) exitIP(node string) (net.IP, error) {
+	client, err := nodeHTTPClient(node, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(g.ipURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("IP查询服务返回了无效的内容")
+	}
+	return ip, nil
+}
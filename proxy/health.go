@@ -0,0 +1,516 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/redis/go-redis/v9"
+
+	"awesomeProject/db"
+)
+
+// NodeOutcome 表示一次请求在某个节点上的结果
+type NodeOutcome string
+
+const (
+	OutcomeSuccess   NodeOutcome = "success"
+	OutcomeThrottled NodeOutcome = "503"
+	OutcomeCaptcha   NodeOutcome = "captcha"
+	OutcomeTimeout   NodeOutcome = "timeout"
+	OutcomeError     NodeOutcome = "error"
+)
+
+// outcomeValues 各结果折算到评分中的值，成功为1，被封禁或超时为0
+var outcomeValues = map[NodeOutcome]float64{
+	OutcomeSuccess:   1,
+	OutcomeThrottled: 0.2,
+	OutcomeCaptcha:   0,
+	OutcomeTimeout:   0,
+	OutcomeError:     0,
+}
+
+// HealthReporter 由支持健康评分的代理提供者实现，抓取结果通过它反馈给评分服务
+type HealthReporter interface {
+	Report(node string, outcome NodeOutcome, latency time.Duration)
+}
+
+// NodeHealth 表示一个节点的健康状态
+type NodeHealth struct {
+	Node                string      `json:"node"`
+	Score               float64     `json:"score"`
+	LatencyMs           float64     `json:"latency_ms"`
+	Successes           int64       `json:"successes"`
+	Failures            int64       `json:"failures"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+	LastOutcome         NodeOutcome `json:"last_outcome"`
+	QuarantineLevel     int         `json:"quarantine_level"`
+	QuarantinedUntil    time.Time   `json:"quarantined_until"`
+	UpdatedAt           time.Time   `json:"updated_at"`
+}
+
+// HealthStore 持久化节点健康状态，重启后恢复
+type HealthStore interface {
+	Load() (map[string]*NodeHealth, error)
+	Save(nodes []*NodeHealth) error
+	Close() error
+}
+
+// Prober 通过指定节点请求目标URL，返回延迟
+type Prober func(node string, target string) (time.Duration, error)
+
+// ErrProbeTimeout 表示测速请求超时，其他测速错误按请求错误计分
+var ErrProbeTimeout = errors.New("测速超时")
+
+// probeOutcome 根据测速错误判断节点的结果
+func probeOutcome(err error) NodeOutcome {
+	var netErr net.Error
+	if errors.Is(err, ErrProbeTimeout) || errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return OutcomeTimeout
+	}
+	return OutcomeError
+}
+
+// HealthService 持续为节点打分：定时用目标站点测速，并汇总抓取时反馈的真实结果，
+// 评分使用EWMA，评分过低的节点按指数退避隔离
+type HealthService struct {
+	store HealthStore
+
+	alpha           float64
+	minScore        float64
+	quarantineBase  time.Duration
+	quarantineMax   time.Duration
+	probeInterval   time.Duration
+	probeConcurrent int
+
+	mu      sync.RWMutex
+	nodes   map[string]*NodeHealth
+	targets map[string]bool
+	dirty   bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewHealthService 创建节点健康评分服务，store为nil时不持久化
+func NewHealthService(store HealthStore) *HealthService {
+	return &HealthService{
+		store:           store,
+		alpha:           envFloat("PROXY_HEALTH_ALPHA", 0.3),
+		minScore:        envFloat("PROXY_HEALTH_MIN_SCORE", 0.4),
+		quarantineBase:  envDuration("PROXY_QUARANTINE_BASE", time.Minute),
+		quarantineMax:   envDuration("PROXY_QUARANTINE_MAX", 30*time.Minute),
+		probeInterval:   envDuration("PROXY_HEALTH_INTERVAL", 5*time.Minute),
+		probeConcurrent: 10,
+		nodes:           make(map[string]*NodeHealth),
+		targets:         make(map[string]bool),
+	}
+}
+
+// Load 从持久化存储中恢复节点评分
+func (h *HealthService) Load() {
+	if h.store == nil {
+		return
+	}
+	nodes, err := h.store.Load()
+	if err != nil {
+		logs.Warn("加载节点健康评分失败:", err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, node := range nodes {
+		h.nodes[name] = node
+	}
+	logs.Info(fmt.Sprintf("加载了%d个节点的健康评分", len(nodes)))
+}
+
+// AddTarget 添加测速目标，通常为任务所在站点的首页
+func (h *HealthService) AddTarget(target string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.targets[target] = true
+}
+
+// Targets 返回当前的测速目标
+func (h *HealthService) Targets() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	targets := make([]string, 0, len(h.targets))
+	for target := range h.targets {
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		targets = append(targets, "https://www.amazon.com/")
+	}
+	return targets
+}
+
+// Report 记录一次请求结果并更新节点评分
+func (h *HealthService) Report(node string, outcome NodeOutcome, latency time.Duration) {
+	if node == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.node(node)
+	now := time.Now()
+
+	value, ok := outcomeValues[outcome]
+	if !ok {
+		value = 0
+	}
+	n.Score = h.alpha*value + (1-h.alpha)*n.Score
+	n.LastOutcome = outcome
+	n.UpdatedAt = now
+	h.dirty = true
+
+	if outcome == OutcomeSuccess {
+		n.Successes++
+		n.ConsecutiveFailures = 0
+		if latency > 0 {
+			ms := float64(latency.Milliseconds())
+			if n.LatencyMs == 0 {
+				n.LatencyMs = ms
+			} else {
+				n.LatencyMs = h.alpha*ms + (1-h.alpha)*n.LatencyMs
+			}
+		}
+		if n.Score >= h.minScore && now.After(n.QuarantinedUntil) {
+			n.QuarantineLevel = 0
+		}
+		return
+	}
+
+	n.Failures++
+	n.ConsecutiveFailures++
+	if n.Score < h.minScore && now.After(n.QuarantinedUntil) {
+		// 指数退避隔离：每次连续被隔离时间加倍
+		coolDown := time.Duration(float64(h.quarantineBase) * math.Pow(2, float64(n.QuarantineLevel)))
+		if coolDown > h.quarantineMax {
+			coolDown = h.quarantineMax
+		}
+		n.QuarantinedUntil = now.Add(coolDown)
+		n.QuarantineLevel++
+		logs.Warn(fmt.Sprintf("节点 %s 评分%.2f过低(%s)，隔离%s", node, n.Score, outcome, coolDown))
+	}
+}
+
+// Available 判断节点当前是否可用（未被隔离）
+func (h *HealthService) Available(node string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n, ok := h.nodes[node]
+	return !ok || time.Now().After(n.QuarantinedUntil)
+}
+
+// Healthy 判断节点未被隔离且评分不低于PROXY_HEALTH_MIN_SCORE，没有记录的节点视为健康
+func (h *HealthService) Healthy(node string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n, ok := h.nodes[node]
+	return !ok || (time.Now().After(n.QuarantinedUntil) && n.Score >= h.minScore)
+}
+
+// Score 返回节点评分，没有记录的节点返回初始评分
+func (h *HealthService) Score(node string) float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if n, ok := h.nodes[node]; ok {
+		return n.Score
+	}
+	return initialScore
+}
+
+// Snapshot 返回全部节点健康状态的副本
+func (h *HealthService) Snapshot() []*NodeHealth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	nodes := make([]*NodeHealth, 0, len(h.nodes))
+	for _, n := range h.nodes {
+		copied := *n
+		nodes = append(nodes, &copied)
+	}
+	return nodes
+}
+
+// Pick 按评分加权随机选择一个未隔离的节点
+func (h *HealthService) Pick(candidates []string) string {
+	total := 0.0
+	weights := make([]float64, len(candidates))
+	for i, name := range candidates {
+		if !h.Available(name) {
+			continue
+		}
+		// 保留最低权重，让低分节点也有机会恢复
+		weights[i] = math.Max(h.Score(name), 0.05)
+		total += weights[i]
+	}
+	if total == 0 {
+		return ""
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if w == 0 {
+			continue
+		}
+		r -= w
+		if r <= 0 {
+			return candidates[i]
+		}
+	}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return candidates[i]
+		}
+	}
+	return ""
+}
+
+const initialScore = 0.8
+
+func (h *HealthService) node(name string) *NodeHealth {
+	n, ok := h.nodes[name]
+	if !ok {
+		n = &NodeHealth{Node: name, Score: initialScore}
+		h.nodes[name] = n
+	}
+	return n
+}
+
+// Start 启动后台测速，nodes返回当前全部节点名称
+func (h *HealthService) Start(nodes func() []string, probe Prober) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	h.done = make(chan struct{})
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.probeInterval)
+		defer ticker.Stop()
+		for {
+			h.ProbeAll(nodes(), probe)
+			h.Persist()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ProbeAll 用每个测速目标测试全部节点
+func (h *HealthService) ProbeAll(nodes []string, probe Prober) {
+	targets := h.Targets()
+	sem := make(chan struct{}, h.probeConcurrent)
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		for _, target := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(node, target string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				latency, err := probe(node, target)
+				if err != nil {
+					h.Report(node, probeOutcome(err), 0)
+					return
+				}
+				h.Report(node, OutcomeSuccess, latency)
+			}(node, target)
+		}
+	}
+	wg.Wait()
+}
+
+// Persist 将有变化的评分写入持久化存储
+func (h *HealthService) Persist() {
+	if h.store == nil {
+		return
+	}
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return
+	}
+	h.dirty = false
+	h.mu.Unlock()
+
+	if err := h.store.Save(h.Snapshot()); err != nil {
+		logs.Warn("保存节点健康评分失败:", err)
+		// 保存失败时保留变化，下次再保存
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+	}
+}
+
+// Stop 停止后台测速，保存评分并关闭持久化存储
+func (h *HealthService) Stop() {
+	if h.cancel != nil {
+		h.cancel()
+		<-h.done
+	}
+	h.Persist()
+	if h.store != nil {
+		if err := h.store.Close(); err != nil {
+			logs.Warn("关闭节点健康评分存储失败:", err)
+		}
+	}
+}
+
+// NewHealthStore 根据类型创建持久化存储: redis、postgres，为空时不持久化
+func NewHealthStore(kind string) (HealthStore, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "redis":
+		postgresDB, err := db.NewPostgresDB()
+		if err != nil {
+			return nil, fmt.Errorf("创建数据库连接失败: %v", err)
+		}
+		defer postgresDB.Close()
+		client, err := postgresDB.NewRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return &RedisHealthStore{client: client, key: "amazon:proxy_node_health"}, nil
+	case "postgres":
+		postgresDB, err := db.NewPostgresDB()
+		if err != nil {
+			return nil, fmt.Errorf("创建数据库连接失败: %v", err)
+		}
+		return &PostgresHealthStore{db: postgresDB}, nil
+	default:
+		return nil, fmt.Errorf("不支持的健康评分存储类型: %s", kind)
+	}
+}
+
+// RedisHealthStore 将节点评分保存在Redis哈希中
+type RedisHealthStore struct {
+	client *redis.Client
+	key    string
+}
+
+func (s *RedisHealthStore) Load() (map[string]*NodeHealth, error) {
+	values, err := s.client.HGetAll(context.Background(), s.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取Redis节点评分失败: %v", err)
+	}
+	nodes := make(map[string]*NodeHealth, len(values))
+	for name, value := range values {
+		n := &NodeHealth{}
+		if err := json.Unmarshal([]byte(value), n); err != nil {
+			continue
+		}
+		nodes[name] = n
+	}
+	return nodes, nil
+}
+
+func (s *RedisHealthStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisHealthStore) Save(nodes []*NodeHealth) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(nodes))
+	for _, n := range nodes {
+		data, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		values[n.Node] = data
+	}
+	if err := s.client.HSet(context.Background(), s.key, values).Err(); err != nil {
+		return fmt.Errorf("写入Redis节点评分失败: %v", err)
+	}
+	return nil
+}
+
+// PostgresHealthStore 将节点评分保存在proxy_node_health表中
+type PostgresHealthStore struct {
+	db *db.PostgresDB
+}
+
+func (s *PostgresHealthStore) Load() (map[string]*NodeHealth, error) {
+	values, err := s.db.LoadProxyHealth()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]*NodeHealth, len(values))
+	for name, value := range values {
+		n := &NodeHealth{}
+		if err := json.Unmarshal(value, n); err != nil {
+			continue
+		}
+		nodes[name] = n
+	}
+	return nodes, nil
+}
+
+func (s *PostgresHealthStore) Close() error {
+	s.db.Close()
+	return nil
+}
+
+func (s *PostgresHealthStore) Save(nodes []*NodeHealth) error {
+	for _, n := range nodes {
+		data, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		if err := s.db.SaveProxyHealth(n.Node, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envFloat 读取浮点数环境变量，未设置或格式错误时返回默认值
+func envFloat(name string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// envInt 读取整数环境变量，未设置或格式错误时返回默认值
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// envLimit 读取上限类的整数环境变量，0表示不限制，未设置、格式错误或为负数时返回默认值
+func envLimit(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// envDuration 读取时长环境变量，例如30s、5m，未设置或格式错误时返回默认值
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
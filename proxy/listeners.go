@@ -0,0 +1,329 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+
+	logs "github.com/danbai225/go-logs"
+	C "github.com/moshaoli688/clash/constant"
+	"github.com/moshaoli688/clash/listener"
+	rules "github.com/moshaoli688/clash/rule"
+	"github.com/moshaoli688/clash/tunnel"
+)
+
+// WorkerListener 表示分配给一个工作协程的本地监听端口，端口上的流量固定走Node节点
+type WorkerListener struct {
+	Port  int
+	Node  string
+	inUse bool
+	// lease 集群节点分配器中的租约ID
+	lease string
+}
+
+// AcquireListener 为工作协程分配一个空闲的本地端口并固定到一个位于country的可用节点，
+// 没有空闲端口时新建一个，不影响其他协程正在使用的节点
+func (c *Clash) AcquireListener(ctx context.Context, country string) (*WorkerListener, error) {
+	nodes, err := c.countryNodes(country)
+	if err != nil {
+		return nil, err
+	}
+
+	c.workerMu.Lock()
+	l, err := c.reserveWorker()
+	var exclude string
+	if l != nil {
+		exclude = l.Node
+	}
+	c.workerMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	node, lease, err := c.leaseNode(ctx, nodes, exclude)
+	if err != nil {
+		c.workerMu.Lock()
+		l.inUse = false
+		c.workerMu.Unlock()
+		return nil, err
+	}
+	c.pin(l, node, lease)
+	logs.Info(fmt.Sprintf("端口%d固定到节点%s", l.Port, node))
+	return l, nil
+}
+
+// RepinListener 将端口切换到另一个位于country的可用节点，用于当前节点被封禁时
+func (c *Clash) RepinListener(ctx context.Context, port int, country string) (*WorkerListener, error) {
+	nodes, err := c.countryNodes(country)
+	if err != nil {
+		return nil, err
+	}
+
+	c.workerMu.Lock()
+	l := c.findWorker(port)
+	var exclude string
+	if l != nil {
+		exclude = l.Node
+	}
+	c.workerMu.Unlock()
+	if l == nil {
+		return nil, fmt.Errorf("端口%d未分配", port)
+	}
+
+	node, lease, err := c.leaseNode(ctx, nodes, exclude)
+	if err != nil || node == exclude {
+		return nil, fmt.Errorf("没有其他可用的代理节点")
+	}
+	c.pin(l, node, lease)
+	logs.Info(fmt.Sprintf("端口%d切换到节点%s", l.Port, node))
+	return l, nil
+}
+
+// ReleaseListener 归还端口，端口保留以便下次复用
+func (c *Clash) ReleaseListener(port int) {
+	c.workerMu.Lock()
+	l := c.findWorker(port)
+	var node, lease string
+	if l != nil {
+		l.inUse = false
+		node, lease = l.Node, l.lease
+		l.lease = ""
+	}
+	c.workerMu.Unlock()
+	if c.allocator != nil {
+		c.allocator.Release(node, lease)
+	}
+}
+
+// reserveWorker 取一个空闲的工作端口并标记为使用中，没有空闲端口时新建一个，调用时需持有workerMu
+func (c *Clash) reserveWorker() (*WorkerListener, error) {
+	for _, w := range c.workers {
+		if !w.inUse {
+			w.inUse = true
+			return w, nil
+		}
+	}
+	l, err := c.addWorker()
+	if err != nil {
+		return nil, err
+	}
+	l.inUse = true
+	return l, nil
+}
+
+// pin 把端口固定到新节点并更新规则，再归还原节点的集群租约
+func (c *Clash) pin(l *WorkerListener, node string, lease string) {
+	c.workerMu.Lock()
+	oldNode, oldLease := l.Node, l.lease
+	l.Node, l.lease = node, lease
+	c.applyRules()
+	c.workerMu.Unlock()
+	if c.allocator != nil {
+		c.allocator.Release(oldNode, oldLease)
+	}
+}
+
+// leaseNode 为端口选择新节点，返回节点和集群租约ID，调用时不能持有workerMu。
+// 设置了集群节点分配器时从健康节点中租用最近最少使用的节点，Redis请求不持有workerMu，
+// 避免阻塞其他协程分配和切换端口；否则在本进程内按健康评分选择
+func (c *Clash) leaseNode(ctx context.Context, nodes []P, exclude string) (string, string, error) {
+	if c.allocator == nil {
+		c.workerMu.Lock()
+		node := c.pickNode(nodes, exclude)
+		c.workerMu.Unlock()
+		if node == "" {
+			return "", "", fmt.Errorf("没有可用的代理节点")
+		}
+		return node, "", nil
+	}
+
+	healthy := make([]string, 0, len(nodes))
+	available := make([]string, 0, len(nodes))
+	for _, p := range nodes {
+		if p.Name == exclude {
+			continue
+		}
+		if c.health != nil && !c.health.Available(p.Name) {
+			continue
+		}
+		available = append(available, p.Name)
+		if c.health == nil || c.health.Healthy(p.Name) {
+			healthy = append(healthy, p.Name)
+		}
+	}
+	// 没有评分达标的节点时，使用未被隔离的节点
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = available
+	}
+	if len(candidates) == 0 {
+		return "", "", fmt.Errorf("没有可用的代理节点")
+	}
+	// Acquire按最近最少使用的顺序选择并发和请求数未满的节点
+	return c.allocator.Acquire(ctx, candidates)
+}
+
+// countryNodes 返回可用节点中与国家匹配的节点，未设置地理位置解析时返回全部可用节点
+func (c *Clash) countryNodes(country string) ([]P, error) {
+	nodes := c.EffectiveProxy()
+	if c.geo == nil {
+		return nodes, nil
+	}
+	names := make([]string, 0, len(nodes))
+	for _, p := range nodes {
+		names = append(names, p.Name)
+	}
+	names, err := c.geo.Filter(names, country)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	matched := make([]P, 0, len(names))
+	for _, p := range nodes {
+		if allowed[p.Name] {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+func (c *Clash) findWorker(port int) *WorkerListener {
+	for _, w := range c.workers {
+		if w.Port == port {
+			return w
+		}
+	}
+	return nil
+}
+
+// pickNode 随机选择一个节点，优先选择没有被其他协程占用的节点，并排除exclude，
+// 设置了健康评分时跳过被隔离的节点，并按评分加权选择
+func (c *Clash) pickNode(nodes []P, exclude string) string {
+	used := make(map[string]bool)
+	for _, w := range c.workers {
+		if w.inUse {
+			used[w.Node] = true
+		}
+	}
+	free := make([]string, 0)
+	others := make([]string, 0)
+	for _, p := range nodes {
+		if p.Name == exclude {
+			continue
+		}
+		if c.health != nil && !c.health.Available(p.Name) {
+			continue
+		}
+		if used[p.Name] {
+			others = append(others, p.Name)
+		} else {
+			free = append(free, p.Name)
+		}
+	}
+	for _, candidates := range [][]string{free, others} {
+		if len(candidates) == 0 {
+			continue
+		}
+		if c.health != nil {
+			if node := c.health.Pick(candidates); node != "" {
+				return node
+			}
+		}
+		return candidates[rand.Intn(len(candidates))]
+	}
+	return ""
+}
+
+// addWorker 新建一个工作端口，端口在检查后被其他进程抢先占用时换下一个端口重试
+func (c *Clash) addWorker() (*WorkerListener, error) {
+	for i := 1; ; i++ {
+		port, err := c.nextWorkerPort()
+		if err != nil {
+			return nil, err
+		}
+		l := &WorkerListener{Port: port}
+		c.workers = append(c.workers, l)
+		err = c.applyListeners()
+		if err == nil {
+			return l, nil
+		}
+		c.workers = c.workers[:len(c.workers)-1]
+		if i >= bindRetries {
+			return nil, err
+		}
+		logs.Warn(err)
+	}
+}
+
+// nextWorkerPort 从workerPortMin开始查找一个未被占用的端口
+func (c *Clash) nextWorkerPort() (int, error) {
+	port := c.workerPortMin
+	if n := len(c.workers); n > 0 {
+		port = c.workers[n-1].Port + 1
+	}
+	for ; port < 65535; port++ {
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		_ = l.Close()
+		return port, nil
+	}
+	return 0, fmt.Errorf("没有可用的本地端口")
+}
+
+// applyListeners 按当前工作端口重建Clash的入站监听
+func (c *Clash) applyListeners() error {
+	inbounds := append([]C.Inbound{}, c.baseInbounds...)
+	for _, w := range c.workers {
+		inbounds = append(inbounds, C.Inbound{
+			Type:        C.InboundTypeMixed,
+			BindAddress: net.JoinHostPort("127.0.0.1", strconv.Itoa(w.Port)),
+		})
+	}
+	listener.ReCreateListeners(inbounds, tunnel.TCPIn(), tunnel.UDPIn())
+
+	// 确认新端口由Clash监听，只有监听成功的入站才会出现在GetInbounds中，
+	// 不能用连接端口来判断，端口可能被其他进程占用
+	last := c.workers[len(c.workers)-1]
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(last.Port))
+	for _, inbound := range listener.GetInbounds() {
+		if inbound.BindAddress == addr {
+			return nil
+		}
+	}
+	return fmt.Errorf("创建监听端口%d失败，端口可能已被占用", last.Port)
+}
+
+// applyRules 更新Clash规则，使工作端口的流量走各自固定的节点
+func (c *Clash) applyRules() {
+	tunnel.UpdateRules(c.pinnedRules())
+}
+
+// pinnedRules 生成INBOUND-PORT规则并放在订阅规则之前，
+// 默认混合端口继续走GLOBAL策略组，保持原有的全局切换行为
+func (c *Clash) pinnedRules() []C.Rule {
+	pinned := make([]C.Rule, 0, len(c.workers)+1+len(c.baseRules))
+	if c.mixedPort != 0 {
+		if rule, err := rules.ParseRule(string(C.RuleConfigInboundPort), strconv.Itoa(c.mixedPort), "GLOBAL", nil); err == nil {
+			pinned = append(pinned, rule)
+		}
+	}
+	for _, w := range c.workers {
+		if w.Node == "" {
+			continue
+		}
+		rule, err := rules.ParseRule(string(C.RuleConfigInboundPort), strconv.Itoa(w.Port), w.Node, nil)
+		if err != nil {
+			logs.Err("生成端口规则失败:", err)
+			continue
+		}
+		pinned = append(pinned, rule)
+	}
+	return append(pinned, c.baseRules...)
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/go-resty/resty/v2"
 	"github.com/moshaoli688/clash/config"
 	C "github.com/moshaoli688/clash/constant"
-	"github.com/moshaoli688/clash/hub"
+	"github.com/moshaoli688/clash/hub/executor"
+	"github.com/moshaoli688/clash/hub/route"
+	"github.com/moshaoli688/clash/tunnel"
 	"go.uber.org/automaxprocs/maxprocs"
 	"io"
 	"math/rand"
@@ -16,6 +18,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,27 +29,87 @@ func init() {
 	rand.NewSource(time.Now().UnixNano())
 }
 
-// UpdateClashConfig 从数据库获取Clash配置，转换后写入clash.yaml文件
+// UpdateClashConfig 从数据库获取订阅地址，转换后写入clash.yaml文件，
+// 默认使用内置转换，SUB_CONVERTER=subconverter时使用configs表中type="conv"的外部subconverter服务。
+// 订阅无法访问或转换失败时，保留上一次成功生成的clash.yaml
 func UpdateClashConfig() error {
+	// 获取当前工作目录
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("获取工作目录失败: %v", err)
+	}
+
+	// 构建clash.yaml文件路径
+	clashYamlPath := filepath.Join(wd, "clash.yaml")
+
+	content, err := FetchClashConfig()
+	if err != nil {
+		if _, statErr := os.Stat(clashYamlPath); statErr == nil {
+			logs.Warn("更新订阅失败，继续使用上一次的clash.yaml:", err)
+			return nil
+		}
+		return err
+	}
+
+	if err := WriteClashConfig(clashYamlPath, content); err != nil {
+		return err
+	}
+	logs.Info("成功更新clash.yaml配置文件")
+	return nil
+}
+
+// WriteClashConfig 先写入临时文件再替换，避免写入中断损坏已有配置
+func WriteClashConfig(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("写入clash.yaml文件失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("写入clash.yaml文件失败: %v", err)
+	}
+	return nil
+}
+
+// FetchClashConfig 拉取configs表中的订阅并转换为Clash配置
+func FetchClashConfig() ([]byte, error) {
 	// 创建数据库连接
 	postgresDB, err := db.NewPostgresDB()
 	if err != nil {
-		return fmt.Errorf("创建数据库连接失败: %v", err)
+		return nil, fmt.Errorf("创建数据库连接失败: %v", err)
 	}
 	defer postgresDB.Close()
 
 	// 获取clash配置
 	clashConfig, err := postgresDB.GetClashConfig()
 	if err != nil {
-		return fmt.Errorf("获取clash配置失败: %v", err)
+		return nil, fmt.Errorf("获取clash配置失败: %v", err)
 	}
 
-	// 获取conv配置
-	convHost, err := postgresDB.GetConvConfig()
+	if os.Getenv("SUB_CONVERTER") == "subconverter" {
+		// 获取conv配置
+		convHost, err := postgresDB.GetConvConfig()
+		if err != nil {
+			return nil, fmt.Errorf("获取conv配置失败: %v", err)
+		}
+		return convertWithSubconverter(convHost, clashConfig)
+	}
+
+	resp, err := resty.New().SetTimeout(30 * time.Second).R().Get(strings.TrimSpace(clashConfig))
 	if err != nil {
-		return fmt.Errorf("获取conv配置失败: %v", err)
+		return nil, fmt.Errorf("拉取订阅失败: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("订阅返回错误状态码: %d", resp.StatusCode())
 	}
+	content, err := ConvertSubscription(resp.Body())
+	if err != nil {
+		return nil, fmt.Errorf("转换订阅失败: %v", err)
+	}
+	return content, nil
+}
 
+// convertWithSubconverter 使用外部subconverter服务转换订阅
+func convertWithSubconverter(convHost string, clashConfig string) ([]byte, error) {
 	// URL编码配置
 	encodedConfig := url.QueryEscape(clashConfig)
 
@@ -58,43 +121,50 @@ func UpdateClashConfig() error {
 		Get(fmt.Sprintf(convHost+":25500/sub?target=clash&url=%s", encodedConfig))
 
 	if err != nil {
-		return fmt.Errorf("请求转换服务失败: %v", err)
+		return nil, fmt.Errorf("请求转换服务失败: %v", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return fmt.Errorf("转换服务返回错误状态码: %d", resp.StatusCode())
+		return nil, fmt.Errorf("转换服务返回错误状态码: %d", resp.StatusCode())
 	}
+	return resp.Body(), nil
+}
 
-	// 获取当前工作目录
-	wd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("获取工作目录失败: %v", err)
-	}
+type Clash struct {
+	configPath string
 
-	// 构建clash.yaml文件路径
-	clashYamlPath := filepath.Join(wd, "clash.yaml")
+	mu      sync.Mutex
+	current string
+	// reloadAlive 热重载前可用的节点，重载会清空Clash的测速记录，重新测速完成前沿用这些节点的状态
+	reloadAlive map[string]bool
 
-	// 写入文件
-	err = os.WriteFile(clashYamlPath, resp.Body(), 0644)
-	if err != nil {
-		return fmt.Errorf("写入clash.yaml文件失败: %v", err)
-	}
+	// 每个工作协程独占的本地监听端口，各自固定到不同节点
+	workerMu      sync.Mutex
+	workers       []*WorkerListener
+	baseInbounds  []C.Inbound
+	baseRules     []C.Rule
+	mixedPort     int
+	workerPortMin int
 
-	logs.Info("成功更新clash.yaml配置文件")
-	return nil
-}
+	// 控制接口地址和密钥，见resolvePorts
+	controllerAddr string
+	secret         string
 
-type Clash struct {
-	configPath string
+	// 节点健康评分，为nil时不参与选择
+	health *HealthService
+	// 节点所在国家，为nil时不按国家选择
+	geo *GeoResolver
+	// 集群节点分配器，为nil时只在本进程内选择节点
+	allocator *Allocator
 }
 
 func New(configPath string) *Clash {
 	return &Clash{configPath: configPath}
 }
-func (c *Clash) Start() {
+func (c *Clash) Start() error {
 	_, err := maxprocs.Set(maxprocs.Logger(func(string, ...any) {}))
 	if err != nil {
-		return
+		return err
 	}
 	C.SetHomeDir(".")
 	if c.configPath != "" {
@@ -109,37 +179,143 @@ func (c *Clash) Start() {
 	}
 	if err := config.Init(C.Path.HomeDir()); err != nil {
 		logs.Err("Initial configuration directory error: %s", err.Error())
-		return
+		return err
 	}
-	if err := hub.Parse(hub.WithExternalController(":9091")); err != nil {
+	cfg, err := executor.Parse()
+	if err != nil {
 		logs.Err("Parse config error: %s", err.Error())
-		return
+		return err
+	}
+	if err := c.resolvePorts(); err != nil {
+		return err
+	}
+	c.prepareConfig(cfg)
+	cfg.Rules = c.pinnedRules()
+	go route.Start(cfg.General.ExternalController, cfg.General.Secret)
+	executor.ApplyConfig(cfg, true)
+	if err := c.waitController(5 * time.Second); err != nil {
+		return err
 	}
-	time.Sleep(time.Second * 1)
+	if err := c.ensureMixedPort(); err != nil {
+		return err
+	}
+	c.workerPortMin = c.mixedPort + 1
+	c.applyRules()
 	c.Speed()
-	c.RandomSelect()
+	if c.RandomSelect() == "" {
+		return fmt.Errorf("没有可用的代理节点")
+	}
+	return nil
 }
+
+// prepareConfig 覆盖订阅中的控制接口和模式，并记录订阅原有的入站和规则
+func (c *Clash) prepareConfig(cfg *config.Config) {
+	cfg.General.ExternalController = c.controllerAddr
+	cfg.General.Secret = c.secret
+	// 使用规则模式，工作端口通过INBOUND-PORT规则固定到各自的节点
+	cfg.General.Mode = tunnel.Rule
+	// 只监听本机，多个容器共用主机网络时互不影响
+	cfg.General.AllowLan = false
+	c.baseInbounds = cfg.Inbounds
+	c.baseRules = cfg.Rules
+	// 订阅中的端口配置不生效，热重载时保持原有的混合端口，避免已分配的工作端口冲突
+	cfg.General.Port = 0
+	cfg.General.SocksPort = 0
+	cfg.General.RedirPort = 0
+	cfg.General.TProxyPort = 0
+	cfg.General.MixedPort = c.mixedPort
+}
+
+// SetHealth 设置节点健康评分服务，选择节点时跳过被隔离的节点并按评分加权
+func (c *Clash) SetHealth(health *HealthService) {
+	c.health = health
+}
+
+// SetGeo 设置节点地理位置解析，分配端口时优先选择与任务国家匹配的节点
+func (c *Clash) SetGeo(geo *GeoResolver) {
+	c.geo = geo
+}
+
+// SetAllocator 设置集群节点分配器，多个容器通过Redis租用节点
+func (c *Clash) SetAllocator(allocator *Allocator) {
+	c.allocator = allocator
+}
+
+// Consume 记录节点的一次请求，节点本分钟的请求预算用完时返回false
+func (c *Clash) Consume(node string) bool {
+	if c.allocator == nil {
+		return true
+	}
+	return c.allocator.Consume(node)
+}
+
+// Speed 使用目标站点对全部节点测速
 func (c *Clash) Speed() {
+	target := "https://www.amazon.com/"
+	if c.health != nil {
+		target = c.health.Targets()[0]
+	}
 	proxies := c.Proxies()
 	group := sync.WaitGroup{}
 	for _, s := range proxies {
+		if !s.IsNode() {
+			continue
+		}
 		group.Add(1)
 		go func() {
-			_, err := http.Get(fmt.Sprintf(`http://127.0.0.1:9091/proxies/%s/delay?timeout=5000&url=%s`, url.PathEscape(s.Name), url.QueryEscape(`https://baidu.com`)))
-			if err != nil {
-				return
-			}
-			group.Done()
+			defer group.Done()
+			_, _ = c.Delay(s.Name, target, 5*time.Second)
 		}()
 	}
 	group.Wait()
 }
+
+// Delay 通过Clash控制接口测试节点访问target的延迟
+func (c *Clash) Delay(name string, target string, timeout time.Duration) (time.Duration, error) {
+	resp, err := c.api(http.MethodGet, fmt.Sprintf(`/proxies/%s/delay?timeout=%d&url=%s`,
+		url.PathEscape(name), timeout.Milliseconds(), url.QueryEscape(target)), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	result := struct {
+		Delay   int    `json:"delay"`
+		Message string `json:"message"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode == http.StatusGatewayTimeout {
+		// Clash在测速超时时返回504
+		return 0, fmt.Errorf("%w: 节点%s", ErrProbeTimeout, name)
+	}
+	if resp.StatusCode != http.StatusOK || result.Delay <= 0 {
+		return 0, fmt.Errorf("节点%s测速失败: %s", name, result.Message)
+	}
+	return time.Duration(result.Delay) * time.Millisecond, nil
+}
+
+// NodeNames 返回全部实际节点的名称
+func (c *Clash) NodeNames() []string {
+	names := make([]string, 0)
+	for _, p := range c.Proxies() {
+		if p.IsNode() {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
 func (c *Clash) Proxies() []P {
-	resp, _ := http.Get(`http://127.0.0.1:9091/proxies`)
+	resp, err := c.api(http.MethodGet, "/proxies", nil)
+	if err != nil {
+		logs.Err("获取节点列表失败:", err)
+		return nil
+	}
+	defer resp.Body.Close()
 	all, _ := io.ReadAll(resp.Body)
 	m := make(map[string]interface{})
 	m2 := make(map[string]P)
-	err := json.Unmarshal(all, &m)
+	err = json.Unmarshal(all, &m)
 	if err != nil {
 		return nil
 	}
@@ -156,35 +332,61 @@ func (c *Clash) Proxies() []P {
 }
 func (c *Clash) Switchover(name string) {
 	jsonName := fmt.Sprintf(`{"name":"%s"}`, name)
-	req, err := http.NewRequest(http.MethodPut, "http://127.0.0.1:9091/proxies/GLOBAL", bytes.NewBufferString(jsonName))
-	if err != nil {
-		logs.Err(err)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
-	_, err = http.DefaultClient.Do(req)
+	resp, err := c.api(http.MethodPut, "/proxies/GLOBAL", bytes.NewBufferString(jsonName))
 	if err != nil {
 		logs.Err(err)
 		return
 	}
+	_ = resp.Body.Close()
 }
 func (c *Clash) EffectiveProxy() []P {
+	c.mu.Lock()
+	reloadAlive := c.reloadAlive
+	c.mu.Unlock()
 	ps := make([]P, 0)
 	for _, p := range c.Proxies() {
-		if len(p.History) > 0 && p.History[len(p.History)-1].Delay > 0 {
+		if !p.IsNode() {
+			continue
+		}
+		if len(p.History) > 0 && p.History[len(p.History)-1].Delay > 0 ||
+			len(p.History) == 0 && reloadAlive[p.Name] {
 			ps = append(ps, p)
 		}
 	}
 	return ps
 }
 func (c *Clash) RandomSelect() string {
-	ps := c.EffectiveProxy()
+	return c.SelectExcept("")
+}
+
+// SelectExcept 随机切换到除exclude以外的可用节点，返回节点名称
+func (c *Clash) SelectExcept(exclude string) string {
+	ps := make([]P, 0)
+	for _, p := range c.EffectiveProxy() {
+		if p.Name != exclude {
+			ps = append(ps, p)
+		}
+	}
+	if len(ps) == 0 {
+		logs.Err("没有可用的代理节点")
+		return ""
+	}
 	name := ps[rand.Int63n(int64(len(ps)))].Name
 	c.Switchover(name)
+	c.mu.Lock()
+	c.current = name
+	c.mu.Unlock()
 	logs.Info("切换为", name)
 	return name
 }
 
+// Current 返回当前选中的节点名称
+func (c *Clash) Current() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
 type P struct {
 	Alive   bool `json:"alive"`
 	History []struct {
@@ -196,3 +398,12 @@ type P struct {
 	Type string `json:"type"`
 	Udp  bool   `json:"udp"`
 }
+
+// IsNode 判断是否为实际的代理节点，排除DIRECT、REJECT和各类策略组
+func (p P) IsNode() bool {
+	switch p.Type {
+	case "Direct", "Reject", "Selector", "URLTest", "Fallback", "LoadBalance", "Relay", "Compatible", "Pass":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/moshaoli688/clash/listener"
+	"github.com/moshaoli688/clash/tunnel"
+)
+
+// 默认端口，被占用时自动选择空闲端口
+const (
+	defaultControllerPort = 9091
+	defaultMixedPort      = 7890
+)
+
+// bindRetries 监听端口被其他进程抢先占用时重新选择端口的次数
+const bindRetries = 3
+
+// controllerClient 请求Clash控制接口使用的客户端
+var controllerClient = &http.Client{Timeout: 30 * time.Second}
+
+// resolvePorts 确定控制接口端口、混合端口和控制接口密钥，
+// 端口来自CLASH_CONTROLLER_PORT和CLASH_MIXED_PORT，未设置时使用默认端口，设置为0或端口被占用时自动选择空闲端口；
+// 密钥来自CLASH_SECRET，未设置时随机生成，控制接口只监听127.0.0.1
+func (c *Clash) resolvePorts() error {
+	controllerPort, err := portFromEnv("CLASH_CONTROLLER_PORT", defaultControllerPort)
+	if err != nil {
+		return err
+	}
+	if controllerPort, err = freePort(controllerPort); err != nil {
+		return fmt.Errorf("选择Clash控制接口端口失败: %v", err)
+	}
+	c.controllerAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(controllerPort))
+
+	mixedPort, err := portFromEnv("CLASH_MIXED_PORT", defaultMixedPort)
+	if err != nil {
+		return err
+	}
+	if mixedPort, err = freePort(mixedPort); err != nil {
+		return fmt.Errorf("选择Clash混合端口失败: %v", err)
+	}
+	c.mixedPort = mixedPort
+
+	c.secret = os.Getenv("CLASH_SECRET")
+	if c.secret == "" {
+		c.secret = newSessionID() + newSessionID()
+	}
+	return nil
+}
+
+// ControllerAddr 返回Clash控制接口地址
+func (c *Clash) ControllerAddr() string {
+	return c.controllerAddr
+}
+
+// ProxyURL 返回默认混合端口的代理地址，该端口的流量走GLOBAL策略组
+func (c *Clash) ProxyURL() string {
+	return "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(c.mixedPort))
+}
+
+// api 请求Clash控制接口，自动附带密钥
+func (c *Clash) api(method string, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, "http://"+c.controllerAddr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+c.secret)
+	}
+	return controllerClient.Do(req)
+}
+
+// portFromEnv 读取端口环境变量，未设置时返回默认端口
+func portFromEnv(name string, defaultPort int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultPort, nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("%s端口配置错误: %s", name, value)
+	}
+	return port, nil
+}
+
+// ensureMixedPort 确认混合端口已经由Clash监听，端口在检查后被其他进程占用时换一个空闲端口重新监听
+func (c *Clash) ensureMixedPort() error {
+	for i := 0; listener.GetPorts().MixedPort != c.mixedPort; i++ {
+		if i >= bindRetries {
+			return fmt.Errorf("监听混合端口%d失败", c.mixedPort)
+		}
+		port, err := freePort(0)
+		if err != nil {
+			return fmt.Errorf("选择Clash混合端口失败: %v", err)
+		}
+		logs.Warn(fmt.Sprintf("混合端口%d已被占用，改用%d", c.mixedPort, port))
+		c.mixedPort = port
+		listener.ReCreatePortsListeners(listener.Ports{MixedPort: port}, tunnel.TCPIn(), tunnel.UDPIn())
+	}
+	return nil
+}
+
+// waitController 等待控制接口启动，并用密钥确认监听端口的是本进程的Clash。
+// 控制接口在进程内只能启动一次，端口被其他进程抢先占用时无法重试，直接返回错误
+func (c *Clash) waitController(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := c.api(http.MethodGet, "/version", nil)
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			return fmt.Errorf("控制接口%s被其他进程占用(状态码%d)，请设置CLASH_CONTROLLER_PORT", c.controllerAddr, resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("控制接口%s启动失败: %v", c.controllerAddr, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// freePort 检查端口是否空闲，为0或已被占用时由系统分配一个空闲端口。
+// 检查后端口会被释放，真正监听前可能被其他进程占用，调用方需要确认监听成功
+func freePort(port int) (int, error) {
+	if port > 0 {
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err == nil {
+			_ = l.Close()
+			return port, nil
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
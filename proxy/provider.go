@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+)
+
+// Endpoint 表示一个可用的代理出口
+type Endpoint struct {
+	// Node 节点名称，用于日志和统计
+	Node string
+	// URL 代理地址，例如http://127.0.0.1:7890，直连时为空
+	URL string
+	// Port 内置Clash分配给该出口的本地端口
+	Port int
+	// Session 轮换网关的粘性会话ID，同一会话使用同一出口IP，其他提供者为空
+	Session string
+}
+
+// ProxyProvider 代理提供者，负责启动代理并为请求分配出口
+type ProxyProvider interface {
+	// Name 返回提供者类型名称
+	Name() string
+	// Start 启动代理，重复调用只会启动一次
+	Start() error
+	// Next 为指定国家代码的任务分配一个出口，ctx取消时停止等待
+	Next(ctx context.Context, country string) (*Endpoint, error)
+	// Rotate 在当前出口被封禁时切换到另一个出口
+	Rotate(ctx context.Context, current *Endpoint, country string) (*Endpoint, error)
+	// Release 归还不再使用的出口
+	Release(endpoint *Endpoint)
+	// Close 释放代理占用的资源
+	Close() error
+}
+
+// 代理提供者类型
+const (
+	ProviderClash   = "clash"
+	ProviderStatic  = "static"
+	ProviderGateway = "gateway"
+	ProviderDirect  = "direct"
+)
+
+// NewProvider 根据类型创建代理提供者，类型为空时读取环境变量PROXY_PROVIDER，默认使用内置Clash
+func NewProvider(kind string) (ProxyProvider, error) {
+	if kind == "" {
+		kind = os.Getenv("PROXY_PROVIDER")
+	}
+	switch strings.ToLower(kind) {
+	case "", ProviderClash:
+		return NewClashProvider("clash.yaml"), nil
+	case ProviderStatic:
+		return NewStaticProvider(os.Getenv("PROXY_LIST_FILE")), nil
+	case ProviderGateway:
+		return NewGatewayProvider(os.Getenv("PROXY_GATEWAY_URL")), nil
+	case ProviderDirect:
+		return DirectProvider{}, nil
+	default:
+		return nil, fmt.Errorf("不支持的代理类型: %s", kind)
+	}
+}
+
+// ClashProvider 使用内置Clash作为代理，进程内只启动一个Clash实例，
+// 每次Next分配一个独立的本地端口并固定到不同节点，并发任务互不影响出口IP
+type ClashProvider struct {
+	clash    *Clash
+	health   *HealthService
+	once     sync.Once
+	startErr error
+}
+
+// NewClashProvider 创建内置Clash代理提供者
+func NewClashProvider(configPath string) *ClashProvider {
+	return &ClashProvider{clash: New(configPath)}
+}
+
+func (p *ClashProvider) Name() string {
+	return ProviderClash
+}
+
+func (p *ClashProvider) Start() error {
+	p.once.Do(func() {
+		store, err := NewHealthStore(os.Getenv("PROXY_HEALTH_STORE"))
+		if err != nil {
+			logs.Warn("节点健康评分不会持久化:", err)
+		}
+		p.health = NewHealthService(store)
+		p.health.Load()
+		p.clash.SetHealth(p.health)
+		if p.startErr = p.clash.Start(); p.startErr != nil {
+			return
+		}
+		if geo, err := LoadGeoResolver(); err != nil {
+			logs.Warn("节点不会按国家选择:", err)
+		} else {
+			p.clash.SetGeo(geo)
+			go geo.ResolveExits(p.clash.NodeNames())
+		}
+		if os.Getenv("PROXY_ALLOCATOR") == "redis" {
+			if allocator, err := NewRedisAllocator(); err != nil {
+				logs.Warn("集群节点分配不可用，只在本进程内选择节点:", err)
+			} else {
+				p.clash.SetAllocator(allocator)
+			}
+		}
+		if interval := envDuration("SUB_REFRESH_INTERVAL", 0); interval > 0 {
+			p.clash.StartRefresh(interval, envInt("PROXY_MIN_NODES", 5))
+		}
+		p.health.Start(p.clash.NodeNames, func(node string, target string) (time.Duration, error) {
+			return p.clash.Delay(node, target, 5*time.Second)
+		})
+	})
+	return p.startErr
+}
+
+func (p *ClashProvider) Next(ctx context.Context, country string) (*Endpoint, error) {
+	if p.health != nil && country != "" {
+		// 用任务所在站点测速，评分更贴近实际抓取
+		p.health.AddTarget(marketplaceURL(country))
+	}
+	l, err := p.clash.AcquireListener(ctx, country)
+	if err != nil {
+		return nil, err
+	}
+	// 检查端口是否可用
+	addr := fmt.Sprintf("127.0.0.1:%d", l.Port)
+	dialer := net.Dialer{Timeout: time.Second * 3}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		p.clash.ReleaseListener(l.Port)
+		return nil, fmt.Errorf("代理端口%d连接失败: %v", l.Port, err)
+	}
+	_ = conn.Close()
+	return &Endpoint{Node: l.Node, URL: "http://" + addr, Port: l.Port}, nil
+}
+
+func (p *ClashProvider) Rotate(ctx context.Context, current *Endpoint, country string) (*Endpoint, error) {
+	if current == nil || current.Port == 0 {
+		return p.Next(ctx, country)
+	}
+	l, err := p.clash.RepinListener(ctx, current.Port, country)
+	if err != nil {
+		return nil, err
+	}
+	return &Endpoint{Node: l.Node, URL: current.URL, Port: l.Port}, nil
+}
+
+func (p *ClashProvider) Release(endpoint *Endpoint) {
+	if endpoint != nil && endpoint.Port != 0 {
+		p.clash.ReleaseListener(endpoint.Port)
+	}
+}
+
+// Report 将抓取结果反馈给节点健康评分
+func (p *ClashProvider) Report(node string, outcome NodeOutcome, latency time.Duration) {
+	if p.health != nil {
+		p.health.Report(node, outcome, latency)
+	}
+}
+
+// Consume 记录一次请求，节点本分钟的请求预算用完时返回false
+func (p *ClashProvider) Consume(endpoint *Endpoint) bool {
+	if endpoint == nil {
+		return true
+	}
+	return p.clash.Consume(endpoint.Node)
+}
+
+func (p *ClashProvider) Close() error {
+	if p.health != nil {
+		p.health.Stop()
+	}
+	if p.clash.allocator != nil {
+		p.clash.allocator.Close()
+	}
+	return nil
+}
+
+// DirectProvider 不使用代理，直接连接
+type DirectProvider struct{}
+
+func (DirectProvider) Name() string {
+	return ProviderDirect
+}
+
+func (DirectProvider) Start() error {
+	return nil
+}
+
+func (DirectProvider) Next(ctx context.Context, country string) (*Endpoint, error) {
+	return &Endpoint{Node: ProviderDirect}, nil
+}
+
+func (DirectProvider) Rotate(ctx context.Context, current *Endpoint, country string) (*Endpoint, error) {
+	return nil, fmt.Errorf("直连模式无法切换出口")
+}
+
+func (DirectProvider) Release(endpoint *Endpoint) {}
+
+func (DirectProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/go-resty/resty/v2"
+	"github.com/moshaoli688/clash/hub/executor"
+)
+
+// Reload 重新读取clash.yaml并热重载正在运行的Clash，不重建监听端口，进行中的请求不受影响。
+// 重载会清空全部节点的测速记录，重载后重新测速，测速完成前沿用重载前的可用状态。
+// 节点被移除的工作端口会重新固定到其他节点，返回新增和移除的节点
+func (c *Clash) Reload() (added []string, removed []string, err error) {
+	before := c.NodeNames()
+
+	cfg, err := executor.Parse()
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析clash.yaml失败: %v", err)
+	}
+
+	alive := make(map[string]bool)
+	for _, p := range c.EffectiveProxy() {
+		alive[p.Name] = true
+	}
+	c.mu.Lock()
+	c.reloadAlive = alive
+	c.mu.Unlock()
+
+	c.workerMu.Lock()
+	c.prepareConfig(cfg)
+	cfg.Rules = c.pinnedRules()
+	// force为false时保留现有的入站监听
+	executor.ApplyConfig(cfg, false)
+	c.workerMu.Unlock()
+
+	after := c.NodeNames()
+	added, removed = diffNodes(before, after)
+	// 测速需要访问网络，不持有workerMu，避免阻塞端口的分配和归还
+	c.Speed()
+	c.mu.Lock()
+	c.reloadAlive = nil
+	c.mu.Unlock()
+
+	// 节点被移除的工作端口切换到其他节点，租用节点时不持有workerMu
+	removedSet := make(map[string]bool, len(removed))
+	for _, name := range removed {
+		removedSet[name] = true
+	}
+	c.workerMu.Lock()
+	stale := make([]*WorkerListener, 0)
+	staleNodes := make([]string, 0)
+	for _, w := range c.workers {
+		if w.Node != "" && removedSet[w.Node] {
+			stale = append(stale, w)
+			staleNodes = append(staleNodes, w.Node)
+		}
+	}
+	c.workerMu.Unlock()
+
+	nodes := c.EffectiveProxy()
+	for i, w := range stale {
+		node, lease, err := c.leaseNode(context.Background(), nodes, staleNodes[i])
+		if err != nil {
+			logs.Err(fmt.Sprintf("节点%s已从订阅中移除，端口%d切换节点失败: %v", staleNodes[i], w.Port, err))
+			continue
+		}
+		c.pin(w, node, lease)
+		logs.Warn(fmt.Sprintf("节点%s已从订阅中移除，端口%d切换到%s", staleNodes[i], w.Port, node))
+	}
+
+	if current := c.Current(); current != "" && removedSet[current] {
+		c.RandomSelect()
+	}
+	if c.geo != nil && len(added) > 0 {
+		go c.geo.ResolveExits(added)
+	}
+	return added, removed, nil
+}
+
+// StartRefresh 定时更新订阅并热重载Clash，可用节点数低于minNodes时告警
+func (c *Clash) StartRefresh(interval time.Duration, minNodes int) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.refresh(minNodes)
+		}
+	}()
+}
+
+func (c *Clash) refresh(minNodes int) {
+	if err := UpdateClashConfig(); err != nil {
+		logs.Err("定时更新订阅失败:", err)
+		return
+	}
+	added, removed, err := c.Reload()
+	if err != nil {
+		logs.Err("热重载Clash配置失败:", err)
+		return
+	}
+	if len(added) > 0 {
+		logs.Info(fmt.Sprintf("订阅新增%d个节点: %v", len(added), added))
+	}
+	if len(removed) > 0 {
+		logs.Info(fmt.Sprintf("订阅移除%d个节点: %v", len(removed), removed))
+	}
+	if live := len(c.EffectiveProxy()); live < minNodes {
+		alert(fmt.Sprintf("可用代理节点数量为%d，低于阈值%d", live, minNodes))
+	}
+}
+
+// diffNodes 比较两次的节点列表，返回新增和移除的节点
+func diffNodes(before []string, after []string) (added []string, removed []string) {
+	old := make(map[string]bool, len(before))
+	for _, name := range before {
+		old[name] = true
+	}
+	current := make(map[string]bool, len(after))
+	for _, name := range after {
+		current[name] = true
+		if !old[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range before {
+		if !current[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// alert 记录告警日志，设置了PROXY_ALERT_WEBHOOK时同时发送到该地址
+func alert(message string) {
+	logs.Err("[告警]", message)
+	webhook := os.Getenv("PROXY_ALERT_WEBHOOK")
+	if webhook == "" {
+		return
+	}
+	resp, err := resty.New().SetTimeout(10 * time.Second).R().
+		SetBody(map[string]string{"text": message}).
+		Post(webhook)
+	if err != nil {
+		logs.Err("发送告警失败:", err)
+		return
+	}
+	if resp.IsError() {
+		logs.Err("发送告警失败，状态码:", resp.StatusCode())
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	logs "github.com/danbai225/go-logs"
+
+	"awesomeProject/db"
+)
+
+// StaticProvider 使用固定的HTTP/HTTPS/SOCKS5代理列表，按顺序轮换
+// 列表来源为PROXY_LIST_FILE指定的文件，未指定时读取configs表中type="proxies"的记录
+type StaticProvider struct {
+	listFile string
+
+	mu      sync.Mutex
+	proxies []string
+	next    int
+	loaded  bool
+}
+
+// NewStaticProvider 创建固定代理列表提供者
+func NewStaticProvider(listFile string) *StaticProvider {
+	return &StaticProvider{listFile: listFile}
+}
+
+func (p *StaticProvider) Name() string {
+	return ProviderStatic
+}
+
+func (p *StaticProvider) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.loaded {
+		return nil
+	}
+
+	var content string
+	if p.listFile != "" {
+		data, err := os.ReadFile(p.listFile)
+		if err != nil {
+			return fmt.Errorf("读取代理列表文件失败: %v", err)
+		}
+		content = string(data)
+	} else {
+		postgresDB, err := db.NewPostgresDB()
+		if err != nil {
+			return fmt.Errorf("创建数据库连接失败: %v", err)
+		}
+		defer postgresDB.Close()
+		content, err = postgresDB.GetProxiesConfig()
+		if err != nil {
+			return fmt.Errorf("获取代理列表失败: %v", err)
+		}
+	}
+
+	proxies, err := ParseProxyList(content)
+	if err != nil {
+		return err
+	}
+	if len(proxies) == 0 {
+		return fmt.Errorf("代理列表为空")
+	}
+	p.proxies = proxies
+	p.loaded = true
+	logs.Info(fmt.Sprintf("加载了%d个固定代理", len(proxies)))
+	return nil
+}
+
+func (p *StaticProvider) Next(ctx context.Context, country string) (*Endpoint, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.proxies) == 0 {
+		return nil, fmt.Errorf("代理列表未加载")
+	}
+	proxyURL := p.proxies[p.next%len(p.proxies)]
+	p.next++
+	return &Endpoint{Node: redactProxyURL(proxyURL), URL: proxyURL}, nil
+}
+
+func (p *StaticProvider) Rotate(ctx context.Context, current *Endpoint, country string) (*Endpoint, error) {
+	p.mu.Lock()
+	count := len(p.proxies)
+	p.mu.Unlock()
+	if count < 2 {
+		return nil, fmt.Errorf("没有其他可用的代理")
+	}
+	for i := 0; i < count; i++ {
+		endpoint, err := p.Next(ctx, country)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || endpoint.URL != current.URL {
+			return endpoint, nil
+		}
+	}
+	return nil, fmt.Errorf("没有其他可用的代理")
+}
+
+func (p *StaticProvider) Release(endpoint *Endpoint) {}
+
+func (p *StaticProvider) Close() error {
+	return nil
+}
+
+// ParseProxyList 解析代理列表，每行或每个逗号分隔一个代理，#开头的行为注释
+// 支持http、https、socks5、socks5h协议，未写协议时默认为http
+func ParseProxyList(content string) ([]string, error) {
+	proxies := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if !strings.Contains(item, "://") {
+				item = "http://" + item
+			}
+			u, err := url.Parse(item)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("代理地址格式错误: %s", item)
+			}
+			switch u.Scheme {
+			case "http", "https", "socks5", "socks5h":
+			default:
+				return nil, fmt.Errorf("不支持的代理协议: %s", u.Scheme)
+			}
+			proxies = append(proxies, u.String())
+		}
+	}
+	return proxies, nil
+}
+
+// redactProxyURL 去掉代理地址中的认证信息，用作节点名称
+func redactProxyURL(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return proxyURL
+	}
+	return u.Scheme + "://" + u.Host
+}
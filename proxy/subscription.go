@@ -0,0 +1,388 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	logs "github.com/danbai225/go-logs"
+	"gopkg.in/yaml.v3"
+)
+
+// supportedProxyTypes 内置Clash核心支持的节点类型
+var supportedProxyTypes = map[string]bool{
+	"ss": true, "ssr": true, "socks5": true, "http": true, "vmess": true, "snell": true, "trojan": true,
+}
+
+// ConvertSubscription 将订阅内容转换为Clash配置，支持Clash YAML和base64编码的ss/vmess/trojan/vless链接列表，
+// 内置Clash核心不支持的节点类型(例如vless)会被跳过，并在转换摘要中按类型列出跳过的数量
+func ConvertSubscription(body []byte) ([]byte, error) {
+	proxies, err := ParseSubscription(body)
+	if err != nil {
+		return nil, err
+	}
+
+	supported := make([]map[string]any, 0, len(proxies))
+	names := make(map[string]int)
+	skipped := make(map[string]int)
+	for _, p := range proxies {
+		kind, _ := p["type"].(string)
+		name, _ := p["name"].(string)
+		if !supportedProxyTypes[kind] {
+			logs.Warn(fmt.Sprintf("跳过不支持的节点类型%s: %s", kind, name))
+			skipped[kind]++
+			continue
+		}
+		// 节点名称必须唯一
+		if n := names[name]; n > 0 {
+			p["name"] = fmt.Sprintf("%s %d", name, n+1)
+		}
+		names[name]++
+		supported = append(supported, p)
+	}
+	summary := fmt.Sprintf("订阅共%d个节点，转换%d个", len(proxies), len(supported))
+	if len(skipped) > 0 {
+		summary += "，内置Clash核心不支持而跳过: " + formatSkipped(skipped)
+	}
+	if len(supported) == 0 {
+		return nil, fmt.Errorf("订阅中没有可用的节点(%s)", summary)
+	}
+	if len(skipped) > 0 {
+		logs.Warn(summary)
+	} else {
+		logs.Info(summary)
+	}
+
+	nodeNames := make([]string, 0, len(supported))
+	for _, p := range supported {
+		nodeNames = append(nodeNames, p["name"].(string))
+	}
+	cfg := map[string]any{
+		"mixed-port": defaultMixedPort,
+		"allow-lan":  false,
+		"mode":       "rule",
+		"log-level":  "warning",
+		"proxies":    supported,
+		"proxy-groups": []map[string]any{
+			{"name": "PROXY", "type": "select", "proxies": nodeNames},
+		},
+		"rules": []string{"MATCH,PROXY"},
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("生成Clash配置失败: %v", err)
+	}
+	return out, nil
+}
+
+// formatSkipped 按类型名称排序输出跳过的节点数量，例如"vless 3个, hysteria2 1个"
+func formatSkipped(skipped map[string]int) string {
+	kinds := make([]string, 0, len(skipped))
+	for kind := range skipped {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		parts = append(parts, fmt.Sprintf("%s %d个", kind, skipped[kind]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseSubscription 解析订阅内容，返回Clash格式的节点列表
+func ParseSubscription(body []byte) ([]map[string]any, error) {
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		return nil, fmt.Errorf("订阅内容为空")
+	}
+
+	// Clash YAML
+	cfg := struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}{}
+	if err := yaml.Unmarshal([]byte(text), &cfg); err == nil && len(cfg.Proxies) > 0 {
+		return cfg.Proxies, nil
+	}
+
+	// base64编码的链接列表，部分订阅直接返回未编码的链接
+	if !strings.Contains(text, "://") {
+		decoded, err := decodeBase64(text)
+		if err != nil {
+			return nil, fmt.Errorf("无法识别的订阅格式")
+		}
+		text = string(decoded)
+	}
+
+	proxies := make([]map[string]any, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		p, err := ParseProxyURI(line)
+		if err != nil {
+			logs.Warn("解析节点链接失败:", err)
+			continue
+		}
+		proxies = append(proxies, p)
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("订阅中没有可解析的节点")
+	}
+	return proxies, nil
+}
+
+// ParseProxyURI 将ss://、vmess://、trojan://、vless://链接转换为Clash节点配置
+func ParseProxyURI(uri string) (map[string]any, error) {
+	scheme, _, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("无效的节点链接: %s", uri)
+	}
+	switch strings.ToLower(scheme) {
+	case "ss":
+		return parseShadowsocks(uri)
+	case "vmess":
+		return parseVmess(uri)
+	case "trojan":
+		return parseTrojan(uri)
+	case "vless":
+		return parseVless(uri)
+	default:
+		return nil, fmt.Errorf("不支持的节点协议: %s", scheme)
+	}
+}
+
+// parseShadowsocks 支持SIP002格式ss://base64(method:password)@host:port/?plugin=...#name
+// 和旧格式ss://base64(method:password@host:port)#name
+func parseShadowsocks(uri string) (map[string]any, error) {
+	body := strings.TrimPrefix(uri, "ss://")
+	body, fragment, _ := strings.Cut(body, "#")
+	name, _ := url.PathUnescape(fragment)
+
+	if !strings.Contains(body, "@") {
+		main, query, _ := strings.Cut(body, "?")
+		decoded, err := decodeBase64(main)
+		if err != nil {
+			return nil, fmt.Errorf("ss链接解码失败: %v", err)
+		}
+		body = string(decoded)
+		if query != "" {
+			body += "/?" + query
+		}
+	}
+	// base64用户信息中可能包含/，按最后一个@手动拆分
+	userinfo := ""
+	if i := strings.LastIndex(body, "@"); i >= 0 {
+		userinfo, body = body[:i], body[i+1:]
+	}
+	u, err := url.Parse("ss://" + body)
+	if err != nil {
+		return nil, fmt.Errorf("ss链接格式错误: %v", err)
+	}
+
+	if !strings.Contains(userinfo, ":") {
+		decoded, err := decodeBase64(userinfo)
+		if err != nil {
+			return nil, fmt.Errorf("ss链接用户信息解码失败: %v", err)
+		}
+		userinfo = string(decoded)
+	}
+	method, password, _ := strings.Cut(userinfo, ":")
+	method, _ = url.PathUnescape(method)
+	password, _ = url.PathUnescape(password)
+
+	p, err := baseProxy("ss", name, u)
+	if err != nil {
+		return nil, err
+	}
+	p["cipher"] = method
+	p["password"] = password
+	p["udp"] = true
+
+	if plugin := u.Query().Get("plugin"); plugin != "" {
+		parts := strings.Split(plugin, ";")
+		opts := make(map[string]any)
+		for _, part := range parts[1:] {
+			key, value, _ := strings.Cut(part, "=")
+			opts[key] = value
+		}
+		switch parts[0] {
+		case "obfs-local", "simple-obfs":
+			p["plugin"] = "obfs"
+			p["plugin-opts"] = map[string]any{"mode": opts["obfs"], "host": opts["obfs-host"]}
+		case "v2ray-plugin":
+			pluginOpts := map[string]any{"mode": "websocket", "host": opts["host"], "path": opts["path"]}
+			if _, ok := opts["tls"]; ok {
+				pluginOpts["tls"] = true
+			}
+			p["plugin"] = "v2ray-plugin"
+			p["plugin-opts"] = pluginOpts
+		default:
+			return nil, fmt.Errorf("不支持的ss插件: %s", parts[0])
+		}
+	}
+	return p, nil
+}
+
+// parseVmess 解析v2rayN格式vmess://base64(json)
+func parseVmess(uri string) (map[string]any, error) {
+	decoded, err := decodeBase64(strings.TrimPrefix(uri, "vmess://"))
+	if err != nil {
+		return nil, fmt.Errorf("vmess链接解码失败: %v", err)
+	}
+	v := struct {
+		PS   string      `json:"ps"`
+		Add  string      `json:"add"`
+		Port json.Number `json:"port"`
+		ID   string      `json:"id"`
+		Aid  json.Number `json:"aid"`
+		Scy  string      `json:"scy"`
+		Net  string      `json:"net"`
+		Type string      `json:"type"`
+		Host string      `json:"host"`
+		Path string      `json:"path"`
+		TLS  string      `json:"tls"`
+		SNI  string      `json:"sni"`
+	}{}
+	if err := json.Unmarshal(decoded, &v); err != nil {
+		return nil, fmt.Errorf("vmess链接格式错误: %v", err)
+	}
+	port, err := strconv.Atoi(v.Port.String())
+	if err != nil {
+		return nil, fmt.Errorf("vmess端口错误: %s", v.Port)
+	}
+	aid, _ := strconv.Atoi(v.Aid.String())
+	cipher := v.Scy
+	if cipher == "" {
+		cipher = "auto"
+	}
+
+	p := map[string]any{
+		"name":    v.PS,
+		"type":    "vmess",
+		"server":  v.Add,
+		"port":    port,
+		"uuid":    v.ID,
+		"alterId": aid,
+		"cipher":  cipher,
+		"udp":     true,
+	}
+	if p["name"] == "" {
+		p["name"] = net.JoinHostPort(v.Add, v.Port.String())
+	}
+	if v.TLS == "tls" {
+		p["tls"] = true
+		if v.SNI != "" {
+			p["servername"] = v.SNI
+		}
+	}
+	applyTransport(p, v.Net, v.Host, v.Path)
+	return p, nil
+}
+
+// parseTrojan 解析trojan://password@host:port?sni=...&type=ws&path=...#name
+func parseTrojan(uri string) (map[string]any, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("trojan链接格式错误: %v", err)
+	}
+	p, err := baseProxy("trojan", u.Fragment, u)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	p["password"] = u.User.Username()
+	p["udp"] = true
+	if sni := query.Get("sni"); sni != "" {
+		p["sni"] = sni
+	}
+	if query.Get("allowInsecure") == "1" {
+		p["skip-cert-verify"] = true
+	}
+	applyTransport(p, query.Get("type"), query.Get("host"), query.Get("path"))
+	return p, nil
+}
+
+// parseVless 解析vless链接。内置Clash核心不支持vless，ConvertSubscription不会使用这些节点，
+// 解析出来是为了在转换摘要中统计跳过的数量
+func parseVless(uri string) (map[string]any, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("vless链接格式错误: %v", err)
+	}
+	p, err := baseProxy("vless", u.Fragment, u)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	p["uuid"] = u.User.Username()
+	p["udp"] = true
+	if security := query.Get("security"); security == "tls" || security == "reality" {
+		p["tls"] = true
+		if sni := query.Get("sni"); sni != "" {
+			p["servername"] = sni
+		}
+	}
+	if flow := query.Get("flow"); flow != "" {
+		p["flow"] = flow
+	}
+	applyTransport(p, query.Get("type"), query.Get("host"), query.Get("path"))
+	return p, nil
+}
+
+// baseProxy 生成包含名称、类型、服务器和端口的节点配置
+func baseProxy(kind string, name string, u *url.URL) (map[string]any, error) {
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, fmt.Errorf("%s节点端口错误: %s", kind, u.Host)
+	}
+	if name == "" {
+		name = u.Host
+	}
+	return map[string]any{
+		"name":   name,
+		"type":   kind,
+		"server": u.Hostname(),
+		"port":   port,
+	}, nil
+}
+
+// applyTransport 设置ws、grpc等传输方式
+func applyTransport(p map[string]any, network string, host string, path string) {
+	switch network {
+	case "ws":
+		p["network"] = "ws"
+		opts := map[string]any{}
+		if path != "" {
+			opts["path"] = path
+		}
+		if host != "" {
+			opts["headers"] = map[string]any{"Host": host}
+		}
+		p["ws-opts"] = opts
+	case "grpc":
+		p["network"] = "grpc"
+		p["grpc-opts"] = map[string]any{"grpc-service-name": path}
+	case "h2":
+		p["network"] = "h2"
+		p["h2-opts"] = map[string]any{"host": []string{host}, "path": path}
+	}
+}
+
+// decodeBase64 依次尝试标准、URL安全以及无填充的base64编码
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		var decoded []byte
+		if decoded, err = enc.DecodeString(s); err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"awesomeProject/parser"
+	"awesomeProject/proxy"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// runProxyCommand 代理相关的子命令，返回进程退出码
+// 用法: awesome proxy doctor [--code US,DE] [--min 3]
+func runProxyCommand(args []string) int {
+	if len(args) == 0 || args[0] != "doctor" {
+		fmt.Fprintln(os.Stderr, "用法: awesome proxy doctor [参数]")
+		return 2
+	}
+	return runProxyDoctor(args[1:])
+}
+
+// runProxyDoctor 依次检查订阅拉取、订阅转换、Clash启动和每个节点访问各站点的状态，
+// 可用节点数少于--min时返回非0
+func runProxyDoctor(args []string) int {
+	fs := flag.NewFlagSet("proxy doctor", flag.ExitOnError)
+	codes := fs.String("code", strings.Join(parser.Codes(), ","), "要测试的站点国家代码，多个用逗号分隔")
+	minNodes := fs.Int("min", envInt("PROXY_MIN_NODES", 1), "每个站点至少需要的可用节点数")
+	configPath := fs.String("config", "clash.yaml", "Clash配置文件路径")
+	skipFetch := fs.Bool("skip-fetch", false, "不拉取订阅，直接使用已有的配置文件")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: awesome proxy doctor [参数]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	marketplaces := make([]string, 0)
+	for _, code := range strings.Split(*codes, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			marketplaces = append(marketplaces, code)
+		}
+	}
+
+	// 1. 拉取并转换订阅
+	if !*skipFetch {
+		content, err := proxy.FetchClashConfig()
+		if err != nil {
+			fmt.Printf("[失败] 订阅: %v\n", err)
+			return 1
+		}
+		nodes, _ := proxy.ParseSubscription(content)
+		fmt.Printf("[正常] 订阅: 拉取并转换成功，%d个节点\n", len(nodes))
+		path, _ := filepath.Abs(*configPath)
+		if err := proxy.WriteClashConfig(path, content); err != nil {
+			fmt.Printf("[失败] 配置: %v\n", err)
+			return 1
+		}
+	}
+
+	// 2. 启动Clash
+	clash := proxy.New(*configPath)
+	if err := clash.Start(); err != nil {
+		fmt.Printf("[警告] Clash: %v\n", err)
+	} else {
+		fmt.Printf("[正常] Clash: 控制接口%s，代理端口%s\n", clash.ControllerAddr(), clash.ProxyURL())
+	}
+	geo, err := proxy.LoadGeoResolver()
+	if err != nil {
+		fmt.Printf("[警告] 节点国家: %v\n", err)
+	}
+
+	// 3. 逐个节点测试
+	reports := clash.Diagnose(marketplaces, geo)
+	if len(reports) == 0 {
+		fmt.Println("[失败] 节点: 没有可用的代理节点")
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "节点\t出口IP\t国家\t延迟\t%s\n", strings.Join(marketplaces, "\t"))
+	for _, r := range reports {
+		latency := "-"
+		if r.Latency > 0 {
+			latency = fmt.Sprintf("%dms", r.Latency.Milliseconds())
+		}
+		statuses := make([]string, 0, len(marketplaces))
+		for _, code := range marketplaces {
+			statuses = append(statuses, r.Status[code])
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Node, orDash(r.ExitIP), orDash(r.Country), latency, strings.Join(statuses, "\t"))
+	}
+	_ = w.Flush()
+
+	// 4. 按站点统计可用节点
+	exitCode := 0
+	for _, code := range marketplaces {
+		usable := 0
+		for _, r := range reports {
+			if r.Usable(code) {
+				usable++
+			}
+		}
+		status := "正常"
+		if usable < *minNodes {
+			status = "失败"
+			exitCode = 1
+		}
+		fmt.Printf("[%s] %s: %d/%d个节点可用\n", status, code, usable, len(reports))
+	}
+	return exitCode
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
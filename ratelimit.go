@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/go-resty/resty/v2"
+)
+
+// tokenBucket 令牌桶，rate为当前每秒产生的令牌数，遇到限流时降低rate，请求成功后逐步恢复到baseRate
+type tokenBucket struct {
+	mu       sync.Mutex
+	baseRate float64
+	minRate  float64
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	// pausedUntil 服务器通过Retry-After要求暂停的截止时间
+	pausedUntil time.Time
+}
+
+func newTokenBucket(qps float64, burst float64) *tokenBucket {
+	return &tokenBucket{
+		baseRate: qps,
+		minRate:  math.Max(qps*envFloat("RATE_LIMIT_MIN_FACTOR", 0.1), 0.01),
+		rate:     qps,
+		burst:    math.Max(burst, 1),
+		tokens:   math.Max(burst, 1),
+		last:     time.Now(),
+	}
+}
+
+// reserve 取出一个令牌，返回需要等待的时长，令牌可以预支，等待结束后即可发送请求
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if pause := b.pausedUntil.Sub(now); pause > wait {
+		wait = pause
+	}
+	return wait
+}
+
+// throttle 速率减半，retryAfter大于0时暂停到该时长之后
+func (b *tokenBucket) throttle(retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = math.Max(b.minRate, b.rate/2)
+	if until := time.Now().Add(retryAfter); retryAfter > 0 && until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// restore 请求成功后速率增加基础速率的10%，直到恢复到基础速率
+func (b *tokenBucket) restore() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = math.Min(b.baseRate, b.rate+b.baseRate*0.1)
+}
+
+// RateLimiter 按亚马逊域名和代理节点分别限速，请求需要同时取得域名和节点的令牌，
+// 遇到503或429时降低速率并遵守Retry-After，请求成功后逐步恢复
+type RateLimiter struct {
+	domainQPS     float64
+	nodeQPS       float64
+	burst         float64
+	maxRetryAfter time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+var (
+	rateLimiter     *RateLimiter
+	rateLimiterOnce sync.Once
+)
+
+// getRateLimiter 返回进程内共享的限速器
+func getRateLimiter() *RateLimiter {
+	rateLimiterOnce.Do(func() {
+		rateLimiter = NewRateLimiter()
+	})
+	return rateLimiter
+}
+
+// NewRateLimiter 创建限速器，参数来自环境变量RATE_LIMIT_DOMAIN_QPS、RATE_LIMIT_NODE_QPS、
+// RATE_LIMIT_BURST和RATE_LIMIT_MAX_RETRY_AFTER，QPS为0表示不限速
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		domainQPS:     envFloat("RATE_LIMIT_DOMAIN_QPS", 2),
+		nodeQPS:       envFloat("RATE_LIMIT_NODE_QPS", 0.5),
+		burst:         envFloat("RATE_LIMIT_BURST", 1),
+		maxRetryAfter: envDuration("RATE_LIMIT_MAX_RETRY_AFTER", 2*time.Minute),
+		buckets:       make(map[string]*tokenBucket),
+	}
+}
+
+// Wait 等待域名和节点的令牌，ctx取消时返回ctx的错误
+func (l *RateLimiter) Wait(ctx context.Context, domain string, node string) error {
+	wait := time.Duration(0)
+	for _, b := range l.bucketsFor(domain, node) {
+		if w := b.reserve(); w > wait {
+			wait = w
+		}
+	}
+	return sleepContext(ctx, wait)
+}
+
+// Throttle 域名或节点被限流，降低速率，retryAfter为服务器要求的等待时长
+func (l *RateLimiter) Throttle(domain string, node string, retryAfter time.Duration) {
+	if retryAfter > l.maxRetryAfter {
+		retryAfter = l.maxRetryAfter
+	}
+	for _, b := range l.bucketsFor(domain, node) {
+		b.throttle(retryAfter)
+	}
+	logs.Info(fmt.Sprintf("%s(%s)被限流，降低请求速率，Retry-After: %s", domain, node, retryAfter))
+}
+
+// Success 请求成功，逐步恢复速率
+func (l *RateLimiter) Success(domain string, node string) {
+	for _, b := range l.bucketsFor(domain, node) {
+		b.restore()
+	}
+}
+
+// Attach 为客户端的全部请求限速，node返回客户端当前使用的代理节点
+func (l *RateLimiter) Attach(client *resty.Client, node func() string) {
+	client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+		return l.Wait(req.Context(), requestHost(req.URL), node())
+	})
+	client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		domain := requestHost(resp.Request.URL)
+		switch code := resp.StatusCode(); {
+		case code == http.StatusServiceUnavailable || code == http.StatusTooManyRequests:
+			l.Throttle(domain, node(), retryAfter(resp.Header()))
+		case code < 400:
+			l.Success(domain, node())
+		}
+		return nil
+	})
+}
+
+func (l *RateLimiter) bucketsFor(domain string, node string) []*tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buckets := make([]*tokenBucket, 0, 2)
+	if l.domainQPS > 0 {
+		buckets = append(buckets, l.bucket("domain:"+domain, l.domainQPS))
+	}
+	if l.nodeQPS > 0 {
+		buckets = append(buckets, l.bucket("node:"+node, l.nodeQPS))
+	}
+	return buckets
+}
+
+func (l *RateLimiter) bucket(key string, qps float64) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newTokenBucket(qps, l.burst)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// retryAfter 解析Retry-After响应头，支持秒数和HTTP日期两种格式
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// requestHost 返回请求地址中的域名
+func requestHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Hostname()
+}
+
+// envFloat 读取浮点数环境变量，未设置或格式错误时返回默认值
+func envFloat(name string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
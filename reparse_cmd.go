@@ -0,0 +1,260 @@
+package main
+
+import (
+	"awesomeProject/archive"
+	"awesomeProject/db"
+	"awesomeProject/parser"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	logs "github.com/danbai225/go-logs"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ReparsedKeyword 表示一个关键词重新解析的结果摘要
+type ReparsedKeyword struct {
+	Keyword string `json:"keyword"`
+	URI     string `json:"uri"`
+	// ArchivedVersion 抓取时的解析器版本
+	ArchivedVersion string `json:"archived_version"`
+	Pages           int    `json:"pages"`
+	Products        int    `json:"products"`
+	Error           string `json:"error,omitempty"`
+}
+
+// runReparseCommand 从归档中重新解析历史任务，并按RESULT_TYPE重新写入结果，返回进程退出码。
+// 只支持search_products任务的搜索结果页，其他类型的归档会被忽略。
+// 有关键词解析失败或没有归档时不写入结果，除非指定--force
+// 用法: awesome reparse --id <任务ID> [--dry-run] [--force]
+func runReparseCommand(args []string) int {
+	fs := flag.NewFlagSet("reparse", flag.ExitOnError)
+	taskID := fs.String("id", "", "任务ID")
+	dryRun := fs.Bool("dry-run", false, "只解析并输出摘要，不写入结果")
+	pretty := fs.Bool("pretty", false, "格式化输出JSON")
+	force := fs.Bool("force", false, "有关键词解析失败或没有归档时仍然重写结果，这些关键词原有的结果会被删除")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: awesome reparse --id <任务ID> [参数]")
+		fmt.Fprintln(fs.Output(), "只支持search_products任务，其他类型(asin_page、keyword_appear)的归档会被忽略")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *taskID == "" {
+		fs.Usage()
+		return 2
+	}
+
+	postgresDB, err := db.NewPostgresDB()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "创建数据库连接失败: %v\n", err)
+		return 1
+	}
+	defer postgresDB.Close()
+
+	taskInfo, err := postgresDB.GetTaskByID(*taskID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	archives, err := postgresDB.ListTaskArchives(*taskID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// 同一关键词多次运行时使用最新的归档
+	latest := make(map[string]db.TaskArchive)
+	keywords := []string{}
+	for _, a := range archives {
+		if a.TaskType != "search_products" {
+			continue
+		}
+		if _, ok := latest[a.Keyword]; !ok {
+			keywords = append(keywords, a.Keyword)
+		}
+		latest[a.Keyword] = a
+	}
+	if len(keywords) == 0 {
+		fmt.Fprintf(os.Stderr, "任务%s没有搜索结果页的归档，reparse只支持search_products任务\n", *taskID)
+		return 1
+	}
+
+	summaries := make([]ReparsedKeyword, 0, len(keywords))
+	tasks := make([]*Task, 0, len(keywords))
+	failed := 0
+	for _, keyword := range keywords {
+		a := latest[keyword]
+		summary := ReparsedKeyword{Keyword: keyword, URI: a.URI, ArchivedVersion: a.ParserVersion}
+		task, err := reparseArchive(taskInfo, a)
+		if err != nil {
+			summary.Error = err.Error()
+			failed++
+		} else {
+			summary.Pages = len(task.Pages)
+			summary.Products = len(task.Result)
+			tasks = append(tasks, task)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	if *pretty {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(summaries); err != nil {
+		fmt.Fprintf(os.Stderr, "输出JSON失败: %v\n", err)
+		return 1
+	}
+
+	if !*dryRun && len(tasks) > 0 {
+		err := applyReparse(context.Background(), taskInfo, summaries, tasks, *force, rewriteResults)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// applyReparse 检查重新解析是否覆盖了任务的全部关键词，再调用rewrite重写结果。
+// MongoDB中的结果不区分关键词，重写时会删除任务原有的全部结果，因此有关键词解析失败或没有归档时
+// 不重写，保留原有的结果，force为true时仍然重写
+func applyReparse(ctx context.Context, taskInfo *db.TaskInfo, summaries []ReparsedKeyword, tasks []*Task, force bool,
+	rewrite func(ctx context.Context, taskID string, tasks []*Task) error) error {
+	if missing := missingKeywords(splitKeywords(taskInfo.Keywords), summaries); len(missing) > 0 && !force {
+		return fmt.Errorf("关键词%v没有重新解析成功，重写会删除这些关键词原有的结果，未写入结果，可以使用--force强制重写", missing)
+	}
+	return rewrite(ctx, taskInfo.TaskID, tasks)
+}
+
+// missingKeywords 返回任务中解析失败或没有归档的关键词
+func missingKeywords(keywords []string, summaries []ReparsedKeyword) []string {
+	reparsed := make(map[string]bool, len(summaries))
+	for _, summary := range summaries {
+		if summary.Error == "" {
+			reparsed[summary.Keyword] = true
+		}
+	}
+	missing := []string{}
+	for _, keyword := range keywords {
+		if !reparsed[keyword] {
+			missing = append(missing, keyword)
+		}
+	}
+	return missing
+}
+
+// reparseArchive 读取一个关键词的归档，用当前解析器重新解析其中的搜索结果页
+func reparseArchive(taskInfo *db.TaskInfo, a db.TaskArchive) (*Task, error) {
+	data, err := archive.OpenURI(a.URI)
+	if err != nil {
+		return nil, fmt.Errorf("读取归档失败: %v", err)
+	}
+	task := &Task{
+		TaskID:   taskInfo.TaskID,
+		TaskType: taskInfo.TaskType,
+		Keyword:  a.Keyword,
+		Category: taskInfo.Category,
+		MaxPage:  taskInfo.PageNum,
+		MinPage:  taskInfo.MinPage,
+		Code:     taskInfo.CountryCode,
+		ZipCode:  taskInfo.Zipcode,
+		Status:   "done",
+		Result:   []Product{},
+	}
+
+	reader := archive.NewReader(bytes.NewReader(data))
+	for {
+		record, err := reader.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.Type != archive.TypeResponse {
+			continue
+		}
+		page, _ := strconv.Atoi(record.Get(archive.HeaderPage))
+		code := record.Get(archive.HeaderCode)
+		if code == "" {
+			code = task.Code
+		}
+		statusCode, _, body, err := archive.ParseHTTPResponse(record.Block)
+		if err != nil {
+			return nil, err
+		}
+		pageRecord := PageResult{Page: page, URL: record.Get("WARC-Target-URI"), StatusCode: statusCode}
+		if statusCode == 200 && !parser.IsCaptchaPage(string(body)) {
+			searchPage, err := parser.ParseSearch(bytes.NewReader(body), parser.Options{
+				Code:  code,
+				Page:  page,
+				Query: task.Keyword,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("解析第%d页失败: %v", page, err)
+			}
+			if task.TotalProducts == nil && searchPage.TotalResults != "" {
+				task.TotalProducts = searchPage.TotalResults
+			}
+			if task.CorrectedQuery == "" {
+				task.CorrectedQuery = searchPage.Outcome.CorrectedQuery
+			}
+			task.Result = append(task.Result, searchPage.Products...)
+			pageRecord.Products = len(searchPage.Products)
+			pageRecord.Outcome = searchPage.Outcome
+		} else {
+			if statusCode == 200 {
+				pageRecord.Error = "验证码页面"
+			} else {
+				pageRecord.Error = fmt.Sprintf("状态码%d", statusCode)
+			}
+			task.Partial = true
+		}
+		task.Pages = append(task.Pages, pageRecord)
+	}
+	return task, nil
+}
+
+// rewriteResults 按RESULT_TYPE重新写入任务结果。MongoDB先写入新结果，全部写入成功后按_id删除原有的结果，
+// 写入失败时删除已写入的新结果，原有的结果保持不变
+func rewriteResults(ctx context.Context, taskID string, tasks []*Task) error {
+	resultType := os.Getenv("RESULT_TYPE")
+	switch resultType {
+	case "redis":
+		for _, task := range tasks {
+			if err := SaveResultsToRedis(ctx, task); err != nil {
+				return fmt.Errorf("保存结果到Redis失败: %v", err)
+			}
+		}
+	case "mongo", "":
+		oldIDs, err := MongoResultIDs(ctx, taskID)
+		if err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			if err := SaveResultsToMongoDB(ctx, task.Result, taskID); err != nil {
+				if cleanupErr := DeleteResultsFromMongoDB(ctx, taskID, bson.M{"_id": bson.M{"$nin": oldIDs}}); cleanupErr != nil {
+					logs.Err("删除已写入的新结果失败:", cleanupErr)
+				}
+				return fmt.Errorf("保存结果到MongoDB失败: %v", err)
+			}
+		}
+		if err := DeleteResultsFromMongoDB(ctx, taskID, bson.M{"_id": bson.M{"$in": oldIDs}}); err != nil {
+			return fmt.Errorf("新结果已写入，删除原有结果失败: %v", err)
+		}
+	default:
+		return fmt.Errorf("不支持的结果类型: %s", resultType)
+	}
+	return nil
+}
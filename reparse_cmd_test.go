@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"awesomeProject/db"
+)
+
+// TestApplyReparseKeepsOldResults 部分关键词重新解析失败或没有归档时，不重写任务原有的结果
+func TestApplyReparseKeepsOldResults(t *testing.T) {
+	taskInfo := &db.TaskInfo{TaskID: "task-1", Keywords: "headphones, earbuds,speaker"}
+	summaries := []ReparsedKeyword{
+		{Keyword: "headphones", Products: 2},
+		{Keyword: "earbuds", Error: "读取归档失败"},
+		// speaker没有归档
+	}
+	tasks := []*Task{{TaskID: "task-1", Keyword: "headphones", Result: []Product{{ASIN: "B000000003"}, {ASIN: "B000000004"}}}}
+
+	newRewrite := func(rows map[string][]Product) func(context.Context, string, []*Task) error {
+		// 与rewriteResults一致：删除任务原有的全部结果，再写入重新解析的结果
+		return func(_ context.Context, taskID string, tasks []*Task) error {
+			delete(rows, taskID)
+			for _, task := range tasks {
+				rows[taskID] = append(rows[taskID], task.Result...)
+			}
+			return nil
+		}
+	}
+	oldRows := func() map[string][]Product {
+		return map[string][]Product{"task-1": {{ASIN: "B000000001"}, {ASIN: "B000000002"}, {ASIN: "B000000005"}}}
+	}
+
+	rows := oldRows()
+	if err := applyReparse(context.Background(), taskInfo, summaries, tasks, false, newRewrite(rows)); err == nil {
+		t.Fatal("partial reparse should be refused")
+	}
+	if len(rows["task-1"]) != 3 || rows["task-1"][0].ASIN != "B000000001" {
+		t.Errorf("old rows = %+v, want them left in place", rows["task-1"])
+	}
+
+	rows = oldRows()
+	if err := applyReparse(context.Background(), taskInfo, summaries, tasks, true, newRewrite(rows)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows["task-1"]) != 2 {
+		t.Errorf("rows after forced rewrite = %+v, want the 2 reparsed products", rows["task-1"])
+	}
+
+	// 全部关键词都重新解析成功时重写
+	rows = oldRows()
+	complete := []ReparsedKeyword{{Keyword: "headphones"}, {Keyword: "earbuds"}, {Keyword: "speaker"}}
+	if err := applyReparse(context.Background(), taskInfo, complete, tasks, false, newRewrite(rows)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows["task-1"]) != 2 {
+		t.Errorf("rows after rewrite = %+v, want the 2 reparsed products", rows["task-1"])
+	}
+}
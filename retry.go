@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// 请求错误分类
+const (
+	ClassTimeout   = "timeout"
+	ClassConnReset = BlockConnReset
+	ClassNetwork   = "network"
+	ClassServer    = "5xx"
+	ClassThrottle  = BlockThrottle
+	ClassCaptcha   = BlockCaptcha
+)
+
+// ErrRetriesExhausted 表示请求在用完重试次数或重试预算后仍然失败
+var ErrRetriesExhausted = errors.New("重试次数已用完")
+
+// RetryRule 一类错误的重试规则
+type RetryRule struct {
+	// MaxAttempts 该类错误在同一URL上最多请求的次数，包含第一次请求
+	MaxAttempts int
+	// Rotate 重试前是否切换代理节点
+	Rotate bool
+}
+
+// RetryPolicy 按错误分类重试，重试前按指数退避加随机抖动等待，
+// 每个页面最多重试PageBudget次，同一任务的全部页面共享任务级预算
+type RetryPolicy struct {
+	Rules      map[string]RetryRule
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	PageBudget int
+	Budget     *RetryBudget
+}
+
+// RetryBudget 任务级重试预算，同一任务的多个关键词共享
+type RetryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// NewRetryBudget 创建任务级重试预算，大小来自环境变量RETRY_TASK_BUDGET
+func NewRetryBudget() *RetryBudget {
+	return &RetryBudget{remaining: envInt("RETRY_TASK_BUDGET", 50)}
+}
+
+// Take 消耗一次重试，预算用完时返回false
+func (b *RetryBudget) Take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// NewRetryPolicy 从环境变量创建重试策略，每类错误的请求次数来自RETRY_<分类>_ATTEMPTS，
+// 例如RETRY_TIMEOUT_ATTEMPTS、RETRY_THROTTLE_ATTEMPTS(503)、RETRY_5XX_ATTEMPTS，
+// 未设置时5xx默认请求2次，其他分类使用PROXY_RETRY_ATTEMPTS
+func NewRetryPolicy(budget *RetryBudget) *RetryPolicy {
+	attempts := envInt("PROXY_RETRY_ATTEMPTS", 3)
+	rule := func(class string, defaultAttempts int, rotate bool) RetryRule {
+		name := "RETRY_" + strings.ToUpper(class) + "_ATTEMPTS"
+		return RetryRule{MaxAttempts: envInt(name, defaultAttempts), Rotate: rotate}
+	}
+	return &RetryPolicy{
+		Rules: map[string]RetryRule{
+			ClassTimeout:   rule(ClassTimeout, attempts, true),
+			ClassConnReset: rule(ClassConnReset, attempts, true),
+			ClassNetwork:   rule(ClassNetwork, attempts, true),
+			ClassServer:    rule(ClassServer, 2, false),
+			ClassThrottle:  rule("throttle", attempts, true),
+			ClassCaptcha:   rule(ClassCaptcha, attempts, true),
+		},
+		BaseDelay:  envDuration("RETRY_BASE_DELAY", time.Second),
+		MaxDelay:   envDuration("RETRY_MAX_DELAY", 30*time.Second),
+		PageBudget: envInt("RETRY_PAGE_BUDGET", 5),
+		Budget:     budget,
+	}
+}
+
+// Rule 返回错误分类的重试规则，未知分类不重试
+func (p *RetryPolicy) Rule(class string) RetryRule {
+	if rule, ok := p.Rules[class]; ok {
+		return rule
+	}
+	return RetryRule{MaxAttempts: 1}
+}
+
+// Backoff 返回第n次重试前的等待时长，BaseDelay*2^(n-1)，不超过MaxDelay，并在后一半区间内随机抖动
+func (p *RetryPolicy) Backoff(n int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// classifyError 返回请求结果的错误分类，请求成功或错误不需要重试(例如404)时返回空字符串
+func classifyError(resp *resty.Response, err error, block string) string {
+	if block != "" {
+		return block
+	}
+	if err != nil {
+		if isTimeout(err) {
+			return ClassTimeout
+		}
+		return ClassNetwork
+	}
+	if resp != nil && resp.StatusCode() >= 500 {
+		return ClassServer
+	}
+	return ""
+}
+
+// isTimeout 判断请求错误是否为超时：客户端超时(resty返回的*url.Error)、连接或读写超时(net.Error)，
+// 以及请求上下文的截止时间
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// retryError 返回重试用完后的错误，封禁类错误包装ErrBlocked，其他包装ErrRetriesExhausted
+func retryError(attempt Attempt, err error) error {
+	target := ErrRetriesExhausted
+	if attempt.Block != "" {
+		target = ErrBlocked
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", target, err)
+	}
+	if attempt.Block != "" {
+		return fmt.Errorf("%w: %s", target, attempt.Block)
+	}
+	return fmt.Errorf("%w: 状态码%d", target, attempt.StatusCode)
+}
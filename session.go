@@ -0,0 +1,163 @@
+package main
+
+import (
+	"awesomeProject/parser"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	logs "github.com/danbai225/go-logs"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ErrZipCodeNotApplied 表示提交邮编后导航栏中的配送地址没有变化
+var ErrZipCodeNotApplied = errors.New("邮编未生效")
+
+// SetAmazonZipCode 通过任务的代理建立亚马逊会话并设置配送邮编：
+// 先访问首页获取会话Cookie，再请求配送地址弹窗获取CSRF令牌，提交邮编后重新加载首页，
+// 确认导航栏中的配送地址已经变为该邮编。失败时切换节点并清空Cookie重试，重试次数来自ZIPCODE_ATTEMPTS
+func SetAmazonZipCode(f *Fetcher, code string, zipCode string) error {
+	if zipCode == "" {
+		return nil // 如果没有设置邮编，直接返回
+	}
+	var err error
+	attempts := envInt("ZIPCODE_ATTEMPTS", 2)
+	for i := 1; i <= attempts; i++ {
+		if err = bootstrapSession(f, code, zipCode); err == nil {
+			return nil
+		}
+		logs.Warn(fmt.Sprintf("第%d次设置邮编%s失败: %v", i, zipCode, err))
+		if f.ctx.Err() != nil {
+			return err
+		}
+		if i < attempts {
+			// 旧节点上建立的会话Cookie可能已被标记，在新节点上重新建立会话
+			_ = f.rotate()
+			f.resetCookies()
+		}
+	}
+	return err
+}
+
+// bootstrapSession 完成一次会话建立和邮编设置
+func bootstrapSession(f *Fetcher, code string, zipCode string) error {
+	homeURL := fmt.Sprintf("https://www.%s/", GetAmazonDomain(code))
+
+	// 1. 访问首页，获取会话Cookie和弹窗令牌
+	doc, err := f.getDocument(homeURL)
+	if err != nil {
+		return fmt.Errorf("加载首页失败: %v", err)
+	}
+	if parser.LocationMatches(parser.DeliveryLocation(doc), zipCode) {
+		// 会话已经是该邮编
+		return nil
+	}
+	if !f.hasCookie(homeURL, "session-id") {
+		return fmt.Errorf("首页没有返回会话Cookie")
+	}
+	modal := parser.ParseLocationModal(doc)
+	if modal == nil || modal.URL == "" {
+		return fmt.Errorf("首页中没有配送地址弹窗")
+	}
+
+	// 2. 请求配送地址弹窗，获取提交邮编使用的CSRF令牌
+	modalURL := parser.AbsoluteURL(code, modal.URL)
+	resp, err := f.Client.R().
+		SetContext(f.ctx).
+		SetHeaders(f.headers).
+		SetHeader("anti-csrftoken-a2z", modal.Token).
+		SetHeader("X-Requested-With", "XMLHttpRequest").
+		SetHeader("Referer", homeURL).
+		Get(modalURL)
+	if err != nil {
+		return fmt.Errorf("请求配送地址弹窗失败: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("配送地址弹窗返回状态码: %d", resp.StatusCode())
+	}
+	token := parser.ParseCSRFToken(resp.String())
+	if token == "" {
+		return fmt.Errorf("配送地址弹窗中没有CSRF令牌")
+	}
+
+	// 3. 提交邮编
+	addressChangeURL := fmt.Sprintf("https://www.%s/portal-migration/hz/glow/address-change?actionSource=glow", GetAmazonDomain(code))
+	resp, err = f.Client.R().
+		SetContext(f.ctx).
+		SetHeaders(f.headers).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Accept", "text/html,*/*").
+		SetHeader("X-Requested-With", "XMLHttpRequest").
+		SetHeader("anti-csrftoken-a2z", token).
+		SetHeader("Referer", homeURL).
+		SetBody(map[string]string{
+			"locationType": "LOCATION_INPUT",
+			"zipCode":      zipCode,
+			"storeContext": "generic",
+			"deviceType":   "web",
+			"pageType":     "Gateway",
+			"actionSource": "glow",
+		}).
+		Post(addressChangeURL)
+	if err != nil {
+		return fmt.Errorf("提交邮编失败: %v", err)
+	}
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("提交邮编返回状态码: %d", resp.StatusCode())
+	}
+	result := struct {
+		IsValidAddress int `json:"isValidAddress"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &result); err == nil && result.IsValidAddress == 0 {
+		return fmt.Errorf("亚马逊不接受邮编%s", zipCode)
+	}
+
+	// 4. 重新加载首页，确认导航栏中的配送地址
+	doc, err = f.getDocument(homeURL)
+	if err != nil {
+		return fmt.Errorf("重新加载首页失败: %v", err)
+	}
+	location := parser.DeliveryLocation(doc)
+	if !parser.LocationMatches(location, zipCode) {
+		return fmt.Errorf("%w: 导航栏显示'%s'", ErrZipCodeNotApplied, location)
+	}
+	logs.Info(fmt.Sprintf("配送地址已设置为 %s", location))
+	return nil
+}
+
+// resetCookies 清空客户端的Cookie，重新写入请求头Profile的语言和货币偏好Cookie
+func (f *Fetcher) resetCookies() {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	f.Client.SetCookieJar(jar)
+	f.profile.Apply(f.Client)
+}
+
+// getDocument 请求页面并解析HTML，不使用响应缓存，建立会话需要服务器返回的Cookie
+func (f *Fetcher) getDocument(pageURL string) (*goquery.Document, error) {
+	resp, _, err := f.fetch(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("状态码: %d", resp.StatusCode())
+	}
+	return goquery.NewDocumentFromReader(strings.NewReader(resp.String()))
+}
+
+// hasCookie 判断客户端是否已经保存了指定站点的Cookie
+func (f *Fetcher) hasCookie(rawURL string, name string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || f.Client.GetClient().Jar == nil {
+		return false
+	}
+	for _, cookie := range f.Client.GetClient().Jar.Cookies(u) {
+		if cookie.Name == name {
+			return true
+		}
+	}
+	return false
+}
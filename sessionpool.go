@@ -0,0 +1,401 @@
+package main
+
+import (
+	"awesomeProject/db"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/redis/go-redis/v9"
+)
+
+// Session 表示一个已经设置好邮编的亚马逊会话，保存Cookie以便其他抓取协程复用
+type Session struct {
+	ID        string          `json:"id"`
+	Code      string          `json:"code"`
+	ZipCode   string          `json:"zip_code"`
+	Node      string          `json:"node"`
+	Profile   string          `json:"profile"`
+	Cookies   []SessionCookie `json:"cookies"`
+	Healthy   bool            `json:"healthy"`
+	Uses      int             `json:"uses"`
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+
+	// Traffic 后台预热会话的代理流量，第一个租用该会话的任务计入自己的流量
+	Traffic map[string]TrafficCounter `json:"traffic,omitempty"`
+}
+
+// SessionCookie 表示会话中的一个Cookie
+type SessionCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SessionPool 按(站点, 邮编)保存一种代理类型预热好的会话，会话存放在Redis中并设置过期时间，
+// 抓取时租用会话，被封禁后淘汰，后台按需补充。会话可以被其他节点上的抓取协程租用，Node只记录最后使用的节点
+type SessionPool struct {
+	client *redis.Client
+	prefix string
+
+	ttl      time.Duration
+	minIdle  int
+	interval time.Duration
+	proxy    string
+
+	// demand 使用过的(站点, 邮编)及最后一次使用的时间，超过会话有效期没有使用时不再补充
+	mu     sync.Mutex
+	demand map[string]time.Time
+
+	// ctx 后台补充会话使用的上下文，Close时取消，正在预热的会话随之停止
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var (
+	sessionPools     = make(map[string]*SessionPool)
+	sessionPoolsLock sync.Mutex
+	// sessionPoolClient 各代理类型的会话池共用的Redis连接，为nil时会话池不可用
+	sessionPoolClient     *redis.Client
+	sessionPoolClientOnce sync.Once
+)
+
+// getSessionPool 返回进程内代理类型对应的会话池，不同代理类型建立的会话互不复用，
+// SESSION_POOL不为redis或Redis不可用时返回nil
+func getSessionPool(proxyKind string) *SessionPool {
+	if os.Getenv("SESSION_POOL") != "redis" {
+		return nil
+	}
+	sessionPoolClientOnce.Do(func() {
+		postgresDB, err := db.NewPostgresDB()
+		if err != nil {
+			logs.Warn("会话池不可用:", err)
+			return
+		}
+		defer postgresDB.Close()
+		client, err := postgresDB.NewRedisClient()
+		if err != nil {
+			logs.Warn("会话池不可用:", err)
+			return
+		}
+		sessionPoolClient = client
+	})
+	if sessionPoolClient == nil {
+		return nil
+	}
+	if proxyKind == "" {
+		proxyKind = os.Getenv("PROXY_PROVIDER")
+	}
+	sessionPoolsLock.Lock()
+	defer sessionPoolsLock.Unlock()
+	pool, ok := sessionPools[proxyKind]
+	if !ok {
+		pool = NewSessionPool(sessionPoolClient, proxyKind)
+		sessionPools[proxyKind] = pool
+		pool.Start()
+	}
+	return pool
+}
+
+// closeSessionPools 停止各会话池的后台补充，等待正在预热的会话退出
+func closeSessionPools() {
+	sessionPoolsLock.Lock()
+	defer sessionPoolsLock.Unlock()
+	for kind, pool := range sessionPools {
+		pool.Close()
+		delete(sessionPools, kind)
+	}
+}
+
+// NewSessionPool 创建会话池，参数来自环境变量SESSION_TTL、SESSION_POOL_MIN_IDLE和SESSION_POOL_INTERVAL
+func NewSessionPool(client *redis.Client, proxyKind string) *SessionPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SessionPool{
+		client:   client,
+		prefix:   "amazon:session",
+		ttl:      envDuration("SESSION_TTL", 30*time.Minute),
+		minIdle:  envInt("SESSION_POOL_MIN_IDLE", 2),
+		interval: envDuration("SESSION_POOL_INTERVAL", time.Minute),
+		proxy:    proxyKind,
+		demand:   make(map[string]time.Time),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start 启动后台补充空闲会话的协程
+func (p *SessionPool) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.replenishLoop()
+	}()
+}
+
+// Close 停止后台补充，取消正在预热的会话并等待协程退出
+func (p *SessionPool) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Lease 为Fetcher租用空闲会话并载入Cookie，没有空闲会话时新建会话并设置邮编
+func (p *SessionPool) Lease(f *Fetcher, code string, zipCode string) (*Session, error) {
+	p.mu.Lock()
+	p.demand[code+"|"+zipCode] = time.Now()
+	p.mu.Unlock()
+
+	for {
+		id, err := p.client.SPop(f.ctx, p.idleKey(code, zipCode)).Result()
+		if errors.Is(err, redis.Nil) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("租用会话失败: %v", err)
+		}
+		session, err := p.load(id)
+		if err != nil || !session.Healthy {
+			// 会话已过期或已被淘汰
+			continue
+		}
+		session.Uses++
+		for node, counter := range session.Traffic {
+			f.traffic.Add(node, counter)
+		}
+		session.Traffic = nil
+		// 沿用会话建立时的浏览器，避免同一会话的User-Agent变化
+		f.useProfile(HeaderProfileByName(code, session.Profile))
+		f.loadCookies(session)
+		logs.Info(fmt.Sprintf("复用会话%s(%s %s，建立于节点%s，当前节点%s)", session.ID, code, zipCode, session.Node, f.Node()))
+		return session, nil
+	}
+
+	if err := SetAmazonZipCode(f, code, zipCode); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &Session{
+		ID:        newSessionID(),
+		Code:      code,
+		ZipCode:   zipCode,
+		Node:      f.Node(),
+		Profile:   f.profile.Name,
+		Healthy:   true,
+		Uses:      1,
+		CreatedAt: now,
+		ExpiresAt: now.Add(p.ttl),
+	}
+	session.Cookies = f.sessionCookies(code)
+	return session, nil
+}
+
+// Return 归还会话，保存最新的Cookie供其他协程复用
+func (p *SessionPool) Return(f *Fetcher, session *Session) {
+	if session == nil || !session.Healthy {
+		return
+	}
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return
+	}
+	session.Node = f.Node()
+	session.Cookies = f.sessionCookies(session.Code)
+	data, err := json.Marshal(session)
+	if err != nil {
+		return
+	}
+	ctx := context.Background()
+	pipe := p.client.TxPipeline()
+	pipe.Set(ctx, p.sessionKey(session.ID), data, ttl)
+	pipe.SAdd(ctx, p.idleKey(session.Code, session.ZipCode), session.ID)
+	pipe.Expire(ctx, p.idleKey(session.Code, session.ZipCode), p.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.Warn("归还会话失败:", err)
+	}
+}
+
+// Retire 淘汰被封禁的会话，保留记录到过期以便排查
+func (p *SessionPool) Retire(session *Session) {
+	if session == nil || !session.Healthy {
+		return
+	}
+	session.Healthy = false
+	logs.Warn(fmt.Sprintf("会话%s(%s %s)被封禁，已淘汰", session.ID, session.Code, session.Node))
+	data, err := json.Marshal(session)
+	if err != nil {
+		return
+	}
+	if ttl := time.Until(session.ExpiresAt); ttl > 0 {
+		_ = p.client.Set(context.Background(), p.sessionKey(session.ID), data, ttl).Err()
+	}
+}
+
+// Idle 返回(站点, 邮编)的空闲会话数
+func (p *SessionPool) Idle(code string, zipCode string) int {
+	n, _ := p.client.SCard(context.Background(), p.idleKey(code, zipCode)).Result()
+	return int(n)
+}
+
+// replenishLoop 定时为最近使用过的(站点, 邮编)补充空闲会话，会话池关闭后退出
+func (p *SessionPool) replenishLoop() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		keys := make([]string, 0, len(p.demand))
+		for key, usedAt := range p.demand {
+			if time.Since(usedAt) > p.ttl {
+				// 超过会话有效期没有任务使用，补充的会话也会在使用前过期
+				delete(p.demand, key)
+				continue
+			}
+			keys = append(keys, key)
+		}
+		p.mu.Unlock()
+
+		for _, key := range keys {
+			code, zipCode, _ := strings.Cut(key, "|")
+			for i := p.Idle(code, zipCode); i < p.minIdle && p.ctx.Err() == nil; i++ {
+				if err := p.warm(code, zipCode); err != nil {
+					logs.Warn(fmt.Sprintf("补充会话(%s %s)失败: %v", code, zipCode, err))
+					break
+				}
+			}
+		}
+	}
+}
+
+// warm 新建一个会话并放入池中
+func (p *SessionPool) warm(code string, zipCode string) error {
+	f := newFetcher(p.ctx, &Task{Code: code, ZipCode: zipCode, Proxy: p.proxy})
+	if f == nil {
+		return fmt.Errorf("代理连接失败")
+	}
+	defer f.provider.Release(f.endpoint)
+	if err := SetAmazonZipCode(f, code, zipCode); err != nil {
+		return err
+	}
+	now := time.Now()
+	p.Return(f, &Session{
+		ID:        newSessionID(),
+		Code:      code,
+		ZipCode:   zipCode,
+		Profile:   f.profile.Name,
+		Healthy:   true,
+		Traffic:   f.traffic.Summary().Nodes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(p.ttl),
+	})
+	return nil
+}
+
+// newSessionID 生成随机会话ID
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (p *SessionPool) load(id string) (*Session, error) {
+	data, err := p.client.Get(context.Background(), p.sessionKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (p *SessionPool) sessionKey(id string) string {
+	return p.prefix + ":" + id
+}
+
+func (p *SessionPool) idleKey(code string, zipCode string) string {
+	return fmt.Sprintf("%s_idle:%s:%s:%s", p.prefix, p.proxy, code, zipCode)
+}
+
+// UseSession 为抓取准备设置好邮编的会话，启用会话池时租用或新建池中的会话，否则直接设置邮编
+func (f *Fetcher) UseSession(code string, zipCode string, proxyKind string) error {
+	f.zipCode = zipCode
+	if cache := getResponseCache(); cache != nil && cache.Mode == CacheOnly {
+		// 只读取缓存时不建立会话
+		return nil
+	}
+	pool := getSessionPool(proxyKind)
+	if pool == nil {
+		return SetAmazonZipCode(f, code, zipCode)
+	}
+	session, err := pool.Lease(f, code, zipCode)
+	if err != nil {
+		return err
+	}
+	f.pool = pool
+	f.session = session
+	return nil
+}
+
+// retireSession 淘汰被封禁的会话并清空客户端中该会话的Cookie，返回是否淘汰了会话
+func (f *Fetcher) retireSession() bool {
+	if f.pool == nil || f.session == nil {
+		return false
+	}
+	f.pool.Retire(f.session)
+	f.session = nil
+	f.resetCookies()
+	return true
+}
+
+// renewSession 淘汰会话并切换节点后重新租用会话，没有可用会话时继续请求，但不再设置邮编
+func (f *Fetcher) renewSession() {
+	session, err := f.pool.Lease(f, f.country, f.zipCode)
+	if err != nil {
+		logs.Warn("重新租用会话失败:", err)
+		return
+	}
+	f.session = session
+}
+
+// loadCookies 将会话的Cookie载入客户端
+func (f *Fetcher) loadCookies(session *Session) {
+	u, err := url.Parse(fmt.Sprintf("https://www.%s/", GetAmazonDomain(session.Code)))
+	if err != nil {
+		return
+	}
+	cookies := make([]*http.Cookie, 0, len(session.Cookies))
+	for _, c := range session.Cookies {
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	if jar := f.Client.GetClient().Jar; jar != nil {
+		jar.SetCookies(u, cookies)
+	}
+}
+
+// sessionCookies 返回客户端中站点的Cookie
+func (f *Fetcher) sessionCookies(code string) []SessionCookie {
+	u, err := url.Parse(fmt.Sprintf("https://www.%s/", GetAmazonDomain(code)))
+	if err != nil || f.Client.GetClient().Jar == nil {
+		return nil
+	}
+	cookies := make([]SessionCookie, 0)
+	for _, c := range f.Client.GetClient().Jar.Cookies(u) {
+		cookies = append(cookies, SessionCookie{Name: c.Name, Value: c.Value})
+	}
+	return cookies
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	logs "github.com/danbai225/go-logs"
+)
+
+var (
+	// errShutdown 收到SIGINT或SIGTERM
+	errShutdown = errors.New("收到退出信号")
+	// errTaskTimeout 任务运行时间超过TASK_TIMEOUT
+	errTaskTimeout = errors.New("超过任务时限")
+)
+
+// newTaskContext 创建任务的上下文，收到SIGINT/SIGTERM或超过TASK_TIMEOUT时取消，
+// 取消原因可以通过context.Cause获取。取消后再次收到信号时进程直接退出
+func newTaskContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := newShutdownContext()
+	taskCtx, cancelTimeout := withTaskTimeout(ctx)
+	return taskCtx, func() {
+		cancelTimeout()
+		cancel()
+	}
+}
+
+// newShutdownContext 创建收到SIGINT/SIGTERM时取消的上下文，取消后再次收到信号时进程直接退出
+func newShutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logs.Warn(fmt.Sprintf("收到信号%v，停止抓取并保存已抓取的结果", sig))
+			cancel(fmt.Errorf("%w: %v", errShutdown, sig))
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return ctx, func() { cancel(nil) }
+}
+
+// withTaskTimeout 为单个任务加上TASK_TIMEOUT时限，未设置时只随ctx取消
+func withTaskTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := envDuration("TASK_TIMEOUT", 0); timeout > 0 {
+		return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w: %s", errTaskTimeout, timeout))
+	}
+	return context.WithCancel(ctx)
+}
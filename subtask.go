@@ -0,0 +1,162 @@
+package main
+
+import (
+	"awesomeProject/db"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 关键词失败的错误分类，请求失败时使用请求的错误分类(timeout、503、captcha等)
+const (
+	ErrorProxy      = "proxy"
+	ErrorZipCode    = "zipcode"
+	ErrorParse      = "parse"
+	ErrorHTTPStatus = "http_status"
+	ErrorNoProducts = "no_products"
+	ErrorPanic      = "panic"
+)
+
+// 关键词子任务的状态，与keywords_scrapy_task的状态一致
+const (
+	SubtaskDone        = "已完成"
+	SubtaskPartial     = "部分完成"
+	SubtaskFailed      = "已失败"
+	SubtaskInterrupted = "已中断"
+)
+
+// fail 记录关键词失败的原因，只保留第一次失败
+func (t *Task) fail(class string, format string, args ...interface{}) {
+	if t.ErrorClass != "" {
+		return
+	}
+	t.ErrorClass = class
+	t.Error = fmt.Sprintf(format, args...)
+}
+
+// failedAttemptClass 返回最后一次请求尝试的错误分类
+func failedAttemptClass(attempts []Attempt) string {
+	for i := len(attempts) - 1; i >= 0; i-- {
+		if attempts[i].Class != "" {
+			return attempts[i].Class
+		}
+	}
+	return ClassNetwork
+}
+
+// subtaskStatus 将ProcessingTask的返回值转换为子任务状态
+func subtaskStatus(result string) string {
+	switch result {
+	case "done", "success":
+		return SubtaskDone
+	case "partial":
+		return SubtaskPartial
+	case "interrupted":
+		return SubtaskInterrupted
+	default:
+		return SubtaskFailed
+	}
+}
+
+// newSubtask 根据关键词任务的执行结果生成子任务记录
+func newSubtask(task *Task, result string, startedAt time.Time) db.Subtask {
+	subtask := db.Subtask{
+		TaskID:     task.TaskID,
+		Keyword:    task.Keyword,
+		Status:     subtaskStatus(result),
+		Products:   len(task.Result),
+		ASINCount:  countASINs(task.Result),
+		ErrorClass: task.ErrorClass,
+		ErrMsg:     task.Error,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	for _, page := range task.Pages {
+		// 状态码为200的验证码页和解析失败的页面也算作失败
+		if page.StatusCode == 200 && page.Error == "" {
+			subtask.Pages++
+		} else {
+			subtask.FailedPages++
+		}
+	}
+	return subtask
+}
+
+// splitKeywords 拆分以逗号分隔的关键词，去除前后的空格，跳过空关键词和重复的关键词，
+// 每个关键词对应一条子任务记录(task_id和keyword唯一)
+func splitKeywords(keywords string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, keyword := range strings.Split(keywords, ",") {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" || seen[keyword] {
+			continue
+		}
+		seen[keyword] = true
+		result = append(result, keyword)
+	}
+	return result
+}
+
+// countASINs 统计不重复的ASIN数量
+func countASINs(products []Product) int {
+	asins := make(map[string]bool)
+	for _, product := range products {
+		if product.ASIN != "" {
+			asins[product.ASIN] = true
+		}
+	}
+	return len(asins)
+}
+
+// SubtaskSummary 汇总任务中各关键词的执行情况
+type SubtaskSummary struct {
+	Done        int
+	Partial     int
+	Failed      int
+	Interrupted int
+	// failures 失败和部分完成的关键词及错误分类
+	failures []string
+}
+
+// summarizeSubtasks 汇总子任务
+func summarizeSubtasks(subtasks []db.Subtask) SubtaskSummary {
+	var summary SubtaskSummary
+	for _, subtask := range subtasks {
+		switch subtask.Status {
+		case SubtaskDone:
+			summary.Done++
+			continue
+		case SubtaskPartial:
+			summary.Partial++
+		case SubtaskInterrupted:
+			summary.Interrupted++
+			continue
+		default:
+			summary.Failed++
+		}
+		class := subtask.ErrorClass
+		if class == "" {
+			class = "unknown"
+		}
+		summary.failures = append(summary.failures, fmt.Sprintf("%s(%s)", subtask.Keyword, class))
+	}
+	return summary
+}
+
+// Total 返回关键词总数
+func (s SubtaskSummary) Total() int {
+	return s.Done + s.Partial + s.Failed + s.Interrupted
+}
+
+// String 返回记录到任务err_msg的摘要
+func (s SubtaskSummary) String() string {
+	msg := fmt.Sprintf("%d个关键词中%d个完成，%d个部分完成，%d个失败", s.Total(), s.Done, s.Partial, s.Failed)
+	if s.Interrupted > 0 {
+		msg += fmt.Sprintf("，%d个中断", s.Interrupted)
+	}
+	if len(s.failures) > 0 {
+		msg += ": " + strings.Join(s.failures, ", ")
+	}
+	return msg
+}
@@ -2,23 +2,22 @@ package main
 
 import (
 	"awesomeProject/db"
+	"awesomeProject/parser"
 	"awesomeProject/proxy"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	logs "github.com/danbai225/go-logs"
 	"github.com/joho/godotenv"
-	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
-	"net"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -43,63 +42,55 @@ type Task struct {
 	TotalResultCount int         `json:"total_result_count"`
 	Code             string      `json:"code"`
 	ZipCode          string      `json:"zip_code"`
+	// Proxy 代理类型(clash/static/gateway/direct)，为空时使用PROXY_PROVIDER
+	Proxy string `json:"proxy"`
+	// Pages 每个页面的抓取结果，CorrectedQuery 为亚马逊自动纠正后的关键词
+	Pages          []PageResult `json:"pages"`
+	CorrectedQuery string       `json:"corrected_query"`
+	// Traffic 任务使用的代理流量
+	Traffic *TrafficStats `json:"traffic"`
+	// RetryBudget 任务级重试预算，Partial 表示有页面在重试后仍然失败，结果不完整
+	RetryBudget *RetryBudget `json:"-"`
+	Partial     bool         `json:"partial"`
+	// Interrupted 表示任务因退出信号或超过时限而提前结束
+	Interrupted bool `json:"interrupted"`
+	// ErrorClass 关键词失败或部分失败的错误分类，Error 为错误描述
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
 }
 
-// Position 表示产品在搜索结果中的位置
-type Position struct {
-	Page           int `json:"page"`
-	Position       int `json:"position"`
-	GlobalPosition int `json:"global_position"`
-}
-
-// Price 表示产品价格信息
-type Price struct {
-	Discounted   bool    `json:"discounted"`
-	CurrentPrice float64 `json:"current_price"`
-	BeforePrice  float64 `json:"before_price"`
-}
-
-// Reviews 表示产品评论信息
-type Reviews struct {
-	TotalReviews int     `json:"total_reviews"`
-	Rating       float64 `json:"rating"`
-}
+// 产品相关结构体定义在parser包中，这里保留别名以兼容原有代码
+type (
+	Position = parser.Position
+	Price    = parser.Price
+	Reviews  = parser.Reviews
+	Product  = parser.Product
+)
 
-// Product 表示产品信息
-type Product struct {
-	Position     Position `json:"position"`
-	ASIN         string   `json:"asin"`
-	Price        Price    `json:"price"`
-	Reviews      Reviews  `json:"reviews"`
-	URL          string   `json:"url"`
-	Sponsored    bool     `json:"sponsored"`
-	AmazonChoice bool     `json:"amazon_choice"`
-	BestSeller   bool     `json:"best_seller"`
-	AmazonPrime  bool     `json:"amazon_prime"`
-	Title        string   `json:"title"`
-	Thumbnail    string   `json:"thumbnail"`
+// PageResult 表示单个页面的抓取结果
+type PageResult struct {
+	Page       int                  `json:"page"`
+	URL        string               `json:"url"`
+	StatusCode int                  `json:"status_code"`
+	Products   int                  `json:"products"`
+	Outcome    parser.SearchOutcome `json:"outcome"`
+	// Attempts 每次请求尝试的记录，Rotations 为因封禁切换节点的次数
+	Attempts  []Attempt `json:"attempts"`
+	Rotations int       `json:"rotations"`
+	// Error 页面请求失败、返回验证码或解析失败的原因，为空表示页面已成功抓取
+	Error string `json:"error,omitempty"`
 }
 
 // 全局变量
 var (
 	handlingTasks     = make([]string, 0)
 	handledRequests   = make([]string, 0)
-	rejectedRequests  = make([]interface{}, 0)
+	rejectedRequests  = make([]string, 0)
 	handlingTasksLock sync.Mutex
 
-	// Amazon域名映射
-	amazonDomains = map[string]string{
-		"US": "amazon.com",
-		"DE": "amazon.de",
-		"UK": "amazon.co.uk",
-		"CA": "amazon.ca",
-		"JP": "amazon.co.jp",
-		"FR": "amazon.fr",
-		"IT": "amazon.it",
-		"ES": "amazon.es",
-		"AU": "amazon.com.au",
-		"MX": "amazon.com.mx",
-	}
+	// 已启动的代理提供者，按类型缓存
+	providers     = make(map[string]proxy.ProxyProvider)
+	providersLock sync.Mutex
 
 	// Amazon默认邮编映射
 	amazonZipCodes = map[string]string{
@@ -115,27 +106,29 @@ var (
 		"MX": "06000",    // 墨西哥城
 		"AE": "00000",    // 迪拜
 	}
-
-	// 当前任务的code
-	currentTaskCode string
 )
 
-// ProcessingTask 处理任务的主函数
-func ProcessingTask(task *Task) string {
+// ProcessingTask 处理任务的主函数，ctx取消后停止抓取新页面，已抓取的结果照常保存
+func ProcessingTask(ctx context.Context, task *Task) string {
 	defer func() {
 		// 捕获任务执行过程中的panic
 		if r := recover(); r != nil {
 			fmt.Printf("任务执行过程中发生panic: %v\n", r)
 			task.Status = "failed"
+			task.fail(ErrorPanic, "%v", r)
 		}
 	}()
 
 	switch task.TaskType {
 	case "search_products":
 		// 搜索产品
-		task.Result = SearchProducts(task)
-		if len(task.Result) == 0 {
-			// 如果没有搜索到产品，返回失败状态
+		task.Result = SearchProducts(ctx, task)
+		if len(task.Result) == 0 && task.Interrupted {
+			return "interrupted"
+		}
+		if len(task.Result) == 0 && !task.NoResults() {
+			// 如果没有搜索到产品且页面不是"无结果"，返回失败状态
+			task.fail(ErrorNoProducts, "没有搜索到产品")
 			return "failed"
 		}
 		task.Status = "done"
@@ -154,34 +147,23 @@ func ProcessingTask(task *Task) string {
 			return "failed"
 		}
 
-		// 根据环境变量决定保存结果的方式
-		resultType := os.Getenv("RESULT_TYPE")
-		fmt.Println("根据环境变量决定保存结果的方式" + resultType)
-		if resultType == "redis" {
-			// 保存结果到Redis队列
-			err1 := SaveResultsToRedis(task)
-			if err1 != nil {
-				logs.Err("保存结果到Redis失败: %v", err1)
-				// 保存结果失败不影响任务状态
-			}
-		} else if resultType == "mongo" || resultType == "" {
-			// 保存结果到MongoDB
-			err2 := SaveResultsToMongoDB(task.Result, task.TaskID)
-			if err2 != nil {
-				logs.Err("保存结果到MongoDB失败: %v", err2)
-				// 保存结果失败不影响任务状态
-			}
+		// 结果由runTask在全部关键词结束后统一保存
+		if task.Interrupted {
+			task.Status = "interrupted"
+		} else if task.Partial {
+			// 有页面在重试后仍然失败，已抓取的结果照常保存，但任务不算完成
+			task.Status = "partial"
 		}
 
 	case "asin_page":
 		// 处理ASIN页面
-		task.Status = ASINPage(task)
+		task.Status = ASINPage(ctx, task)
 		if task.Status == "" {
 			return "failed"
 		}
 	case "keyword_appear":
 		// 检查关键词出现
-		task.Status = KeywordAppear(task)
+		task.Status = KeywordAppear(ctx, task)
 		if task.Status == "" {
 			return "failed"
 		}
@@ -191,36 +173,62 @@ func ProcessingTask(task *Task) string {
 	return task.Status
 }
 
-func createClient() *resty.Client {
-	clash := proxy.New("clash.yaml")
-	clash.Start()
-	// 创建代理URL
-	proxyURL, err := url.Parse("http://127.0.0.1:7890")
-	if err != nil {
-		return nil
-	} else {
-		fmt.Println(proxyURL.String())
+// saveTaskResults 按RESULT_TYPE保存一个关键词的结果，任务被取消后仍然保存已抓取的结果，保存失败不影响任务状态
+func saveTaskResults(ctx context.Context, task *Task) {
+	saveCtx := context.WithoutCancel(ctx)
+
+	// 根据环境变量决定保存结果的方式
+	resultType := os.Getenv("RESULT_TYPE")
+	fmt.Println("根据环境变量决定保存结果的方式" + resultType)
+	if resultType == "redis" {
+		// 保存结果到Redis队列
+		if err := SaveResultsToRedis(saveCtx, task); err != nil {
+			logs.Err("保存结果到Redis失败: %v", err)
+		}
+	} else if resultType == "mongo" || resultType == "" {
+		// 保存结果到MongoDB
+		if err := SaveResultsToMongoDB(saveCtx, task.Result, task.TaskID); err != nil {
+			logs.Err("保存结果到MongoDB失败: %v", err)
+		}
 	}
-	// 检查端口是否可用
-	conn, err := net.DialTimeout("tcp", "127.0.0.1:7890", time.Second*3)
-	if err != nil {
-		fmt.Println("代理端口7890连接失败:", err)
-		return nil
+}
+
+// getProxyProvider 获取并启动代理提供者，同一类型在进程内只启动一次
+func getProxyProvider(kind string) (proxy.ProxyProvider, error) {
+	if kind == "" {
+		kind = os.Getenv("PROXY_PROVIDER")
 	}
-	errCon := conn.Close()
-	if errCon != nil {
-		return nil
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	provider, ok := providers[kind]
+	if !ok {
+		var err error
+		provider, err = proxy.NewProvider(kind)
+		if err != nil {
+			return nil, err
+		}
+		providers[kind] = provider
+	}
+	if err := provider.Start(); err != nil {
+		return nil, fmt.Errorf("启动代理%s失败: %v", provider.Name(), err)
 	}
-	fmt.Println("代理端口7890连接成功")
-	// 创建resty客户端并设置代理
-	client := resty.New()
-	client.SetProxy("http://127.0.0.1:7890")
-	client.SetTimeout(30 * time.Second)
-	return client
+	return provider, nil
 }
 
-// SearchProducts 处理搜索产品任务
-func SearchProducts(task *Task) []Product {
+// closeProviders 关闭已启动的代理提供者，归还节点租约并保存健康评分
+func closeProviders() {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	for kind, provider := range providers {
+		if err := provider.Close(); err != nil {
+			logs.Warn(fmt.Sprintf("关闭代理%s失败: %v", kind, err))
+		}
+		delete(providers, kind)
+	}
+}
+
+// SearchProducts 处理搜索产品任务，ctx取消后不再翻页，返回已抓取的结果
+func SearchProducts(ctx context.Context, task *Task) []Product {
 	var mu sync.Mutex
 
 	kw := task.Keyword
@@ -239,14 +247,19 @@ func SearchProducts(task *Task) []Product {
 	handlingTasksLock.Unlock()
 
 	// 创建HTTP客户端
-	client := createClient()
-	if client == nil {
+	fetcher := newFetcher(ctx, task)
+	if fetcher == nil {
 		fmt.Println("<UNK>代理连接失败，任务取消")
+		task.Interrupted = ctx.Err() != nil
+		task.fail(ErrorProxy, "代理连接失败")
 		return nil
 	}
-	// 设置当前任务的code
-	currentTaskCode = task.Code
-	amazonDomain := GetAmazonDomain(currentTaskCode)
+	defer fetcher.Close()
+	amazonDomain := GetAmazonDomain(task.Code)
+
+	// 归档抓取到的原始页面，便于解析器修复后重新解析
+	pageArchive := newTaskArchive(task)
+	defer pageArchive.Close()
 
 	// 如果设置了邮编，先设置亚马逊的邮编
 	zipCode := ""
@@ -258,14 +271,14 @@ func SearchProducts(task *Task) []Product {
 	}
 
 	if zipCode != "" {
-		err := SetAmazonZipCode(client, amazonDomain, zipCode)
-		if err != nil {
-			logs.Warn("设置亚马逊邮编失败:", err)
-			// 即使设置邮编失败，我们仍然继续爬取
-		} else {
-			logs.Info("成功设置亚马逊邮编:", zipCode)
+		// 邮编影响价格和库存，设置失败时任务失败
+		if err := fetcher.UseSession(task.Code, zipCode, task.Proxy); err != nil {
+			logs.Err("设置亚马逊邮编失败:", err)
+			task.Interrupted = ctx.Err() != nil
+			task.fail(ErrorZipCode, "设置邮编失败: %v", err)
+			return nil
 		}
-		//return nil
+		logs.Info("成功设置亚马逊邮编:", zipCode)
 	}
 
 	allResults := []Product{}
@@ -281,51 +294,82 @@ func SearchProducts(task *Task) []Product {
 
 	// 循环获取所有页面
 	for currentPage <= maxPage && pageCount < maxPage {
+		if ctx.Err() != nil {
+			logs.Warn(fmt.Sprintf("任务被中断(%v)，关键词 '%s' 停止在第%d页", context.Cause(ctx), kw, currentPage))
+			task.Interrupted = true
+			break
+		}
 		log.Printf("<%s> start search keyword: %s, page: %d, URL: %s",
 			time.Now().Format("2006-01-02 15:04:05"), kw, currentPage, kwSearchURL)
 
 		fmt.Println(kwSearchURL)
-		headers := map[string]string{
-			"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		resp, attempts, err := fetcher.Get(kwSearchURL)
+		pageArchive.Page(currentPage, kwSearchURL, resp)
+		pageRecord := newPageResult(currentPage, kwSearchURL, resp, attempts)
+		if err != nil && ctx.Err() != nil {
+			// 请求被取消，当前页不记录
+			task.Interrupted = true
+			break
 		}
-		resp, err := client.R().
-			SetHeaders(headers).
-			Get(kwSearchURL)
 		if err != nil {
 			log.Printf("[ERROR] <%s> keyword: %s, page: %d, error: %v",
 				time.Now().Format("2006-01-02 15:04:05"), task.Keyword, currentPage, err)
+			if errors.Is(err, ErrBlocked) && resp != nil {
+				PushRejectedRequests(resp)
+			}
+			pageRecord.Error = err.Error()
+			task.Pages = append(task.Pages, pageRecord)
+			task.Partial = true
+			task.fail(failedAttemptClass(attempts), "第%d页请求失败: %v", currentPage, err)
 			break
 		}
 
 		if resp.StatusCode() == 200 {
-			respHTML := resp.String()
-
-			// 解析HTML
-			doc, err := goquery.NewDocumentFromReader(strings.NewReader(respHTML))
+			// 解析页面
+			searchPage, err := parser.ParseSearch(strings.NewReader(resp.String()), parser.Options{
+				Code:  task.Code,
+				Page:  currentPage,
+				Query: kw,
+			})
 			if err != nil {
 				log.Printf("[ERROR] <%s> Failed to parse HTML: %v",
 					time.Now().Format("2006-01-02 15:04:05"), err)
+				pageRecord.Error = fmt.Sprintf("解析失败: %v", err)
+				task.Pages = append(task.Pages, pageRecord)
+				task.Partial = true
+				task.fail(ErrorParse, "第%d页解析失败: %v", currentPage, err)
 				break
 			}
 
 			// 提取总结果数
-			re := regexp.MustCompile(`"totalResultCount":(\w+.[0-9])`)
-			matches := re.FindStringSubmatch(respHTML)
-			if len(matches) > 1 {
+			if searchPage.TotalResults != "" {
 				mu.Lock()
 				if task.TotalProducts == nil {
-					task.TotalProducts = matches[1]
+					task.TotalProducts = searchPage.TotalResults
 				}
 				mu.Unlock()
 			}
 
-			// 解析产品
-			pageResult := ScrapePageProds(doc, currentPage)
-			log.Printf("<%s> ======  search keyword: %s, page: %d is done, result length: %d  ======",
-				time.Now().Format("2006-01-02 15:04:05"), kw, currentPage, len(pageResult))
+			// 识别无结果、纠正关键词等情况
+			outcome := searchPage.Outcome
+			if outcome.CorrectedQuery != "" && task.CorrectedQuery == "" {
+				task.CorrectedQuery = outcome.CorrectedQuery
+				logs.Warn(fmt.Sprintf("关键词 '%s' 被亚马逊纠正为 '%s' (%s)", kw, outcome.CorrectedQuery, outcome.Kind))
+			}
+
+			pageResult := searchPage.Products
+			log.Printf("<%s> ======  search keyword: %s, page: %d is done, result length: %d, outcome: %s  ======",
+				time.Now().Format("2006-01-02 15:04:05"), kw, currentPage, len(pageResult), outcome.Kind)
 
 			// 添加到结果集
 			allResults = append(allResults, pageResult...)
+			pageRecord.Products = len(pageResult)
+			pageRecord.Outcome = outcome
+			task.Pages = append(task.Pages, pageRecord)
+			if outcome.NoResults() {
+				// 页面没有商品，不再翻页
+				break
+			}
 
 			// 记录已处理的请求
 			StackInHandledRequests(fmt.Sprintf("%s_%d", task.Keyword, currentPage))
@@ -339,28 +383,14 @@ func SearchProducts(task *Task) []Product {
 				break
 			}
 
-			// 从页面中提取下一页链接
-			nextPageLink := doc.Find(".s-pagination-item.s-pagination-next:not(.s-pagination-disabled)")
-			if nextPageLink.Length() == 0 {
-				// 没有下一页按钮，结束循环
+			// 没有下一页按钮，结束循环
+			if !searchPage.HasNextPage {
 				break
 			}
 
-			// 获取下一页的实际URL
-			nextPageHref, exists := nextPageLink.Attr("href")
-			if exists {
-				fmt.Println(nextPageHref)
-				// 使用从页面中提取的下一页链接
-				if strings.HasPrefix(nextPageHref, "/") {
-					// 相对URL，需要添加域名
-					kwSearchURL = fmt.Sprintf("https://www.%s%s", amazonDomain, nextPageHref)
-				} else if strings.HasPrefix(nextPageHref, "http") {
-					// 完整URL，直接使用
-					kwSearchURL = nextPageHref
-				} else {
-					// 其他情况，构建完整URL
-					kwSearchURL = fmt.Sprintf("https://www.%s/%s", amazonDomain, nextPageHref)
-				}
+			// 使用从页面中提取的下一页链接
+			if searchPage.NextPageURL != "" {
+				kwSearchURL = searchPage.NextPageURL
 			} else {
 				// 如果无法获取href属性，使用默认构建的URL
 				kwSearchURL = fmt.Sprintf("https://www.%s/s?k=%s&page=%d",
@@ -370,12 +400,12 @@ func SearchProducts(task *Task) []Product {
 				}
 			}
 
-		} else if resp.StatusCode() == 503 {
-			PushRejectedRequests(resp)
-			log.Printf("Error: %d", resp.StatusCode())
-			break
 		} else {
 			log.Printf("Error: %d", resp.StatusCode())
+			pageRecord.Error = fmt.Sprintf("状态码%d", resp.StatusCode())
+			task.Pages = append(task.Pages, pageRecord)
+			task.Partial = true
+			task.fail(ErrorHTTPStatus, "第%d页状态码%d", currentPage, resp.StatusCode())
 			break
 		}
 	}
@@ -409,186 +439,26 @@ func SearchProducts(task *Task) []Product {
 	return allResults
 }
 
-// ScrapePageProds 解析页面中的产品信息
-func ScrapePageProds(doc *goquery.Document, page int) []Product {
-	prodList := []Product{}
-
-	try := func() {
-		eleSearchResults := doc.Find(".s-search-results [data-component-type=\"s-search-result\"]")
-		prodsCount := eleSearchResults.Length()
-		globalPosition := prodsCount * (page - 1)
-
-		eleSearchResults.Each(func(idx int, item *goquery.Selection) {
-			prodItem := Product{}
-
-			// 解析价格
-			elePrice := item.Find("span[data-a-size=\"xl\"]").First()
-			if elePrice.Length() == 0 {
-				elePrice = item.Find("span[data-a-size=\"l\"]").First()
-			}
-			if elePrice.Length() == 0 {
-				elePrice = item.Find("span[data-a-size=\"m\"]").First()
-			}
-
-			eleDiscounted := item.Find("span.a-price.a-text-price")
-			currentPriceText := ""
-			if elePrice.Length() > 0 {
-				currentPriceText = elePrice.Find("span").Text()
-			}
-
-			discountPriceText := ""
-			if eleDiscounted.Length() > 0 {
-				discountPriceText = eleDiscounted.Find("span").Text()
-			}
-
-			// 解析产品链接
-			eleProdLink := item.Find("span[data-component-type=\"s-product-image\"] a")
-			productURL := ""
-			if eleProdLink.Length() > 0 {
-				productURL, _ = eleProdLink.Attr("href")
-			}
-
-			// 解析评论
-			eleReviews := item.Find("[data-csa-c-slot-id=\"alf-reviews\"] a")
-			reviewsText := ""
-			if eleReviews.Length() > 0 {
-				reviewsText, _ = eleReviews.Attr("aria-label")
-			}
-
-			// 解析星级
-			eleStar := item.Find("a.mvt-review-star-mini-popover,.a-icon-star-small")
-			starText := ""
-			if eleStar.Length() > 0 {
-				starText, _ = eleStar.Attr("aria-label")
-			}
-
-			// 处理特定地区的格式
-			// 注意：在Go中我们无法直接获取window.location.host，这里需要根据实际情况调整
-			// 这里假设我们有一个函数来检查当前是否是德国或意大利站点
-			if IsGermanOrItalianSite() {
-				currentPriceText = strings.ReplaceAll(currentPriceText, ".", "")
-				currentPriceText = strings.ReplaceAll(currentPriceText, ",", ".")
-				discountPriceText = strings.ReplaceAll(discountPriceText, ".", "")
-				discountPriceText = strings.ReplaceAll(discountPriceText, ",", ".")
-				reviewsText = strings.ReplaceAll(reviewsText, ".", "")
-				starText = strings.ReplaceAll(starText, ",", ".")
-			}
-
-			// 设置位置信息
-			prodItem.Position = Position{
-				Page:           page,
-				Position:       idx + 1,
-				GlobalPosition: globalPosition + idx + 1,
-			}
-
-			// 设置ASIN
-			prodItem.ASIN, _ = item.Attr("data-asin")
-
-			// 设置价格信息
-			currentPrice := 0.0
-			if currentPriceText != "" {
-				re := regexp.MustCompile(`[^\d.]`)
-				currentPriceClean := re.ReplaceAllString(currentPriceText, "")
-				currentPrice, _ = strconv.ParseFloat(currentPriceClean, 64)
-			}
-
-			beforePrice := 0.0
-			if discountPriceText != "" {
-				re := regexp.MustCompile(`[^\d.]`)
-				beforePriceClean := re.ReplaceAllString(discountPriceText, "")
-				beforePrice, _ = strconv.ParseFloat(beforePriceClean, 64)
-			}
-
-			prodItem.Price = Price{
-				Discounted:   eleDiscounted.Length() > 0,
-				CurrentPrice: currentPrice,
-				BeforePrice:  beforePrice,
-			}
-
-			// 设置评论信息
-			totalReviews := 0
-			if reviewsText != "" {
-				re := regexp.MustCompile(`,`)
-				reviewsClean := re.ReplaceAllString(reviewsText, "")
-				totalReviews, _ = strconv.Atoi(reviewsClean)
-			}
-
-			rating := 0.0
-			if starText != "" {
-				rating, _ = strconv.ParseFloat(starText, 64)
-			}
-
-			prodItem.Reviews = Reviews{
-				TotalReviews: totalReviews,
-				Rating:       rating,
-			}
-
-			// 设置URL
-			amazonDomain := GetAmazonDomain(currentTaskCode)
-			if productURL != "" {
-				// 检查productURL是否包含完整域名，如果不包含则添加
-				if strings.HasPrefix(productURL, "/") {
-					prodItem.URL = fmt.Sprintf("https://www.%s%s", amazonDomain, productURL)
-				} else {
-					prodItem.URL = productURL
-				}
-			} else {
-				prodItem.URL = fmt.Sprintf("https://www.%s/dp/%s", amazonDomain, prodItem.ASIN)
-			}
-
-			// 设置其他属性
-			prodItem.Sponsored = item.Find("span.puis-sponsored-label-info-icon").Length() > 0 || strings.Contains(prodItem.URL, "/sspa/")
-			prodItem.AmazonChoice = item.Find(fmt.Sprintf("span[id='%s-amazons-choice']", prodItem.ASIN)).Length() > 0
-			prodItem.BestSeller = item.Find(fmt.Sprintf("span[id='%s-best-seller']", prodItem.ASIN)).Length() > 0
-			prodItem.AmazonPrime = item.Find(".s-prime").Length() > 0
-
-			// 设置标题
-			eleTitle := item.Find("[data-cy=\"title-recipe\"] span.a-text-normal")
-			if eleTitle.Length() == 0 {
-				eleTitle = item.Find("[data-cy=\"title-recipe\"] h2.a-size-base-plus span")
-			}
-			if eleTitle.Length() == 0 {
-				eleTitle = item.Find("[data-cy=\"title-recipe\"] h2.a-size-medium span")
-			}
-			if eleTitle.Length() > 0 {
-				prodItem.Title = eleTitle.Text()
-				fmt.Println(prodItem.Title)
-			}
-
-			// 设置缩略图
-			eleThumbnail := item.Find("img[data-image-source-density=\"1\"]")
-			if eleThumbnail.Length() > 0 {
-				prodItem.Thumbnail, _ = eleThumbnail.Attr("src")
-			}
-
-			prodList = append(prodList, prodItem)
-		})
-	}
-
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("[ERROR] <scrape_page_prods> %v", r)
-		}
-	}()
-
-	try()
-
-	return prodList
-}
-
 // ASINPage 处理ASIN页面任务
-func ASINPage(task *Task) string {
+func ASINPage(ctx context.Context, task *Task) string {
 	// 添加到处理中的任务
 	handlingTasksLock.Lock()
 	handlingTasks = append(handlingTasks, fmt.Sprintf("%s_%s", task.TaskID, task.ASIN))
 	handlingTasksLock.Unlock()
 
 	// 创建HTTP客户端
-	client := createClient()
+	fetcher := newFetcher(ctx, task)
 
 	status := "error"
 
 	try := func() string {
+		if fetcher == nil {
+			fmt.Println("<UNK>代理连接失败，任务取消")
+			task.Interrupted = ctx.Err() != nil
+			task.fail(ErrorProxy, "代理连接失败")
+			return "error"
+		}
+		defer fetcher.Close()
 		log.Printf("<%s> start fetch asin page asin: %s", time.Now().Format("2006-01-02 15:04:05"), task.ASIN)
 
 		// 获取对应的Amazon域名
@@ -604,24 +474,36 @@ func ASINPage(task *Task) string {
 		}
 
 		if zipCode != "" {
-			err := SetAmazonZipCode(client, amazonDomain, zipCode)
-			if err != nil {
-				logs.Warn("设置亚马逊邮编失败:", err)
-				// 即使设置邮编失败，我们仍然继续爬取
-			} else {
-				logs.Info("成功设置亚马逊邮编:", zipCode)
+			// 邮编影响价格和库存，设置失败时任务失败
+			if err := fetcher.UseSession(task.Code, zipCode, task.Proxy); err != nil {
+				logs.Err("设置亚马逊邮编失败:", err)
+				task.Interrupted = ctx.Err() != nil
+				task.fail(ErrorZipCode, "设置邮编失败: %v", err)
+				return "error"
 			}
+			logs.Info("成功设置亚马逊邮编:", zipCode)
 		}
 
 		// 构建ASIN页面URL
 		asinURL := fmt.Sprintf("https://www.%s/dp/%s", amazonDomain, task.ASIN)
-		headers := map[string]string{
-			"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
-		}
-		resp, err := client.R().SetHeaders(headers).Get(asinURL)
+		resp, attempts, err := fetcher.Get(asinURL)
+		pageArchive := newTaskArchive(task)
+		pageArchive.Page(1, asinURL, resp)
+		pageArchive.Close()
+		pageRecord := newPageResult(1, asinURL, resp, attempts)
 
 		if err != nil {
 			log.Printf("[ERROR] <%s> asin: %s, error: %v", time.Now().Format("2006-01-02 15:04:05"), task.ASIN, err)
+			if ctx.Err() != nil {
+				task.Interrupted = true
+				return "interrupted"
+			}
+			if errors.Is(err, ErrBlocked) && resp != nil {
+				PushRejectedRequests(resp)
+			}
+			pageRecord.Error = err.Error()
+			task.Pages = append(task.Pages, pageRecord)
+			task.fail(failedAttemptClass(attempts), "请求失败: %v", err)
 			return "error"
 		}
 
@@ -630,18 +512,21 @@ func ASINPage(task *Task) string {
 			_, err := goquery.NewDocumentFromReader(strings.NewReader(resp.String()))
 			if err != nil {
 				log.Printf("[ERROR] <%s> Failed to parse HTML: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+				pageRecord.Error = fmt.Sprintf("解析失败: %v", err)
+				task.Pages = append(task.Pages, pageRecord)
+				task.fail(ErrorParse, "解析失败: %v", err)
 				return "error"
 			}
+			task.Pages = append(task.Pages, pageRecord)
 
 			log.Printf("<%s> ======  search asin: %s is done  ======", time.Now().Format("2006-01-02 15:04:05"), task.ASIN)
 			fmt.Println(resp.String())
 			return "success"
-		} else if resp.StatusCode() == 503 {
-			PushRejectedRequests(resp)
-			log.Printf("Error: %d", resp.StatusCode())
-			return "error"
 		} else {
 			log.Printf("Error: %d", resp.StatusCode())
+			pageRecord.Error = fmt.Sprintf("状态码%d", resp.StatusCode())
+			task.Pages = append(task.Pages, pageRecord)
+			task.fail(ErrorHTTPStatus, "状态码%d", resp.StatusCode())
 			return "error"
 		}
 	}
@@ -665,30 +550,64 @@ func ASINPage(task *Task) string {
 }
 
 // KeywordAppear 处理关键词出现任务
-func KeywordAppear(task *Task) string {
+func KeywordAppear(ctx context.Context, task *Task) string {
 	// 添加到处理中的任务
 	handlingTasksLock.Lock()
 	handlingTasks = append(handlingTasks, fmt.Sprintf("%s_%s_%s", task.TaskID, task.Keyword, task.ASIN))
 	handlingTasksLock.Unlock()
 
-	// 创建HTTP客户端
-	client := resty.New()
-	client.SetTimeout(30 * time.Second)
-	client.SetHeader("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	// 创建HTTP客户端，与搜索任务一样经过代理、重试和邮编设置
+	fetcher := newFetcher(ctx, task)
 
 	status := "error"
 
 	try := func() string {
+		if fetcher == nil {
+			fmt.Println("<UNK>代理连接失败，任务取消")
+			task.Interrupted = ctx.Err() != nil
+			task.fail(ErrorProxy, "代理连接失败")
+			return "error"
+		}
+		defer fetcher.Close()
 		log.Printf("<%s> start keyword_appear keyword: %s, asin: %s", time.Now().Format("2006-01-02 15:04:05"), task.Keyword, task.ASIN)
 
 		// 获取对应的Amazon域名
 		amazonDomain := GetAmazonDomain(task.Code)
 
+		// 如果设置了邮编，先设置亚马逊的邮编
+		zipCode := task.ZipCode
+		if zipCode == "" && task.Code != "" {
+			zipCode = GetAmazonZipCode(task.Code)
+		}
+		if zipCode != "" {
+			if err := fetcher.UseSession(task.Code, zipCode, task.Proxy); err != nil {
+				logs.Err("设置亚马逊邮编失败:", err)
+				task.Interrupted = ctx.Err() != nil
+				task.fail(ErrorZipCode, "设置邮编失败: %v", err)
+				return "error"
+			}
+			logs.Info("成功设置亚马逊邮编:", zipCode)
+		}
+
 		appearURL := fmt.Sprintf("https://www.%s/s?k=%s&field-asin=%s", amazonDomain, url.QueryEscape(task.Keyword), task.ASIN)
-		resp, err := client.R().Get(appearURL)
+		resp, attempts, err := fetcher.Get(appearURL)
+		pageArchive := newTaskArchive(task)
+		pageArchive.Page(1, appearURL, resp)
+		pageArchive.Close()
+		pageRecord := newPageResult(1, appearURL, resp, attempts)
 
 		if err != nil {
 			log.Printf("[ERROR] <%s> keyword: %s, asin: %s, error: %v", time.Now().Format("2006-01-02 15:04:05"), task.Keyword, task.ASIN, err)
+			if ctx.Err() != nil {
+				task.Interrupted = true
+				return "interrupted"
+			}
+			if errors.Is(err, ErrBlocked) && resp != nil {
+				PushRejectedRequests(resp)
+			}
+			pageRecord.Error = err.Error()
+			task.Pages = append(task.Pages, pageRecord)
+			task.fail(failedAttemptClass(attempts), "请求失败: %v", err)
 			return "error"
 		}
 
@@ -697,22 +616,22 @@ func KeywordAppear(task *Task) string {
 			doc, err := goquery.NewDocumentFromReader(strings.NewReader(resp.String()))
 			if err != nil {
 				log.Printf("[ERROR] <%s> Failed to parse HTML: %v", time.Now().Format("2006-01-02 15:04:05"), err)
+				pageRecord.Error = fmt.Sprintf("解析失败: %v", err)
+				task.Pages = append(task.Pages, pageRecord)
+				task.fail(ErrorParse, "解析失败: %v", err)
 				return "error"
 			}
 
 			// 检查是否有结果
-			searchResultsText := doc.Find("[data-component-type='s-search-results']").Text()
-			isNoResult := strings.Contains(searchResultsText, "No results for") ||
-				strings.Contains(searchResultsText, "Aucun résultat pour") ||
-				strings.Contains(searchResultsText, "Keine Ergebnisse für") ||
-				strings.Contains(searchResultsText, "Nessun risultato per") ||
-				strings.Contains(searchResultsText, "No hay resultados para") ||
-				strings.Contains(searchResultsText, "没有") ||
-				strings.Contains(searchResultsText, "の検索に一致する商品はありませんでした")
-
-			searchResultsSize := doc.Find(".s-search-results [data-component-type='s-search-result']").Length()
-
-			if isNoResult {
+			outcome := parser.DetectSearchOutcome(doc, task.Keyword)
+			task.CorrectedQuery = outcome.CorrectedQuery
+			pageRecord.Products = doc.Find(".s-search-results [data-component-type='s-search-result']").Length()
+			pageRecord.Outcome = outcome
+			task.Pages = append(task.Pages, pageRecord)
+
+			searchResultsSize := task.Pages[len(task.Pages)-1].Products
+
+			if outcome.NoResults() {
 				task.Appear = "N"
 				task.TotalResultCount = 0
 			} else {
@@ -722,12 +641,11 @@ func KeywordAppear(task *Task) string {
 
 			log.Printf("<%s> ======  search keyword: %s, asin: %s is done  ======", time.Now().Format("2006-01-02 15:04:05"), task.Keyword, task.ASIN)
 			return "success"
-		} else if resp.StatusCode() == 503 {
-			PushRejectedRequests(resp)
-			log.Printf("Error: %d", resp.StatusCode())
-			return "error"
 		} else {
 			log.Printf("Error: %d", resp.StatusCode())
+			pageRecord.Error = fmt.Sprintf("状态码%d", resp.StatusCode())
+			task.Pages = append(task.Pages, pageRecord)
+			task.fail(ErrorHTTPStatus, "状态码%d", resp.StatusCode())
 			return "error"
 		}
 	}
@@ -750,18 +668,34 @@ func KeywordAppear(task *Task) string {
 	return status
 }
 
+// NoResults 判断任务的搜索结果页是否为"无结果"
+func (t *Task) NoResults() bool {
+	return len(t.Pages) > 0 && t.Pages[0].Outcome.NoResults()
+}
+
+// maxTrackedRequests 已处理和被拒绝的请求最多保留的条数，worker模式下进程长期运行，只保留最近的记录
+const maxTrackedRequests = 1000
+
 // StackInHandledRequests 添加到已处理请求
 func StackInHandledRequests(key string) {
 	handlingTasksLock.Lock()
 	defer handlingTasksLock.Unlock()
-	handledRequests = append(handledRequests, key)
+	handledRequests = appendRecent(handledRequests, key)
 }
 
-// PushRejectedRequests 添加到被拒绝请求
+// PushRejectedRequests 添加到被拒绝请求，只记录状态码和URL，不保留响应内容
 func PushRejectedRequests(resp *resty.Response) {
 	handlingTasksLock.Lock()
 	defer handlingTasksLock.Unlock()
-	rejectedRequests = append(rejectedRequests, resp)
+	rejectedRequests = appendRecent(rejectedRequests, fmt.Sprintf("%d %s", resp.StatusCode(), resp.Request.URL))
+}
+
+// appendRecent 添加一条记录，超过maxTrackedRequests时丢弃最早的记录
+func appendRecent(records []string, record string) []string {
+	if len(records) >= maxTrackedRequests {
+		records = append(records[:0], records[len(records)-maxTrackedRequests+1:]...)
+	}
+	return append(records, record)
 }
 
 // PopHandlingTask 移除处理中的任务
@@ -773,16 +707,23 @@ func PopHandlingTask() {
 	}
 }
 
-// IsGermanOrItalianSite 检查是否是德国或意大利站点
-func IsGermanOrItalianSite() bool {
-	return currentTaskCode == "DE" || currentTaskCode == "IT"
-}
-
 // 主函数示例
 func main() {
 	//if main1() {
 	//	return
 	//}
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "parse":
+			os.Exit(runParseCommand(os.Args[2:]))
+		case "proxy":
+			os.Exit(runProxyCommand(os.Args[2:]))
+		case "reparse":
+			os.Exit(runReparseCommand(os.Args[2:]))
+		}
+	}
+
 	// 在需要更新配置的地方调用
 	nUp := os.Getenv("NEED_UPDATE")
 	if nUp == "true" {
@@ -793,8 +734,14 @@ func main() {
 		}
 	}
 
+	// worker模式: 常驻进程，消费Redis任务队列
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		os.Exit(runWorkerCommand(os.Args[2:]))
+	}
+
 	// 解析命令行参数，现在只需要任务ID
 	taskID := flag.String("id", "", "任务ID")
+	proxyKind := flag.String("proxy", "", "代理类型: clash/static/gateway/direct，默认读取PROXY_PROVIDER")
 
 	// 解析命令行参数
 	flag.Parse()
@@ -806,6 +753,12 @@ func main() {
 		return
 	}
 
+	// 收到SIGINT/SIGTERM或超过TASK_TIMEOUT时取消任务：停止抓取新页面，保存已抓取的结果
+	ctx, cancel := newTaskContext()
+	defer cancel()
+	defer closeProviders()
+	defer closeSessionPools()
+
 	// 创建数据库连接
 	postgresDB, err := db.NewPostgresDB()
 	if err != nil {
@@ -814,26 +767,40 @@ func main() {
 	}
 	defer postgresDB.Close()
 
+	runTask(ctx, postgresDB, *taskID, *proxyKind)
+}
+
+// runTask 执行一个任务的全部关键词，记录每个关键词的子任务，保存结果并更新任务状态，返回任务整体的执行结果：
+// done、partial(部分关键词失败)、failed，任务被中断时返回interrupted
+func runTask(ctx context.Context, postgresDB *db.PostgresDB, taskID string, proxyKind string) string {
 	// 从数据库获取任务信息
-	taskInfo, err := postgresDB.GetTaskByID(*taskID)
+	taskInfo, err := postgresDB.GetTaskByID(taskID)
 	if err != nil {
 		fmt.Printf("获取任务信息失败: %v\n", err)
-		return
+		return "failed"
 	}
 
 	// 拆分关键词（以逗号分隔）
-	keywords := strings.Split(taskInfo.Keywords, ",")
-	// 去除关键词前后的空格
-	for i := range keywords {
-		keywords[i] = strings.TrimSpace(keywords[i])
+	keywords := splitKeywords(taskInfo.Keywords)
+	if len(keywords) == 0 {
+		if updateErr := postgresDB.UpdateTaskFailed(taskID, "任务没有关键词"); updateErr != nil {
+			fmt.Printf("更新任务状态失败: %v\n", updateErr)
+		}
+		return "failed"
 	}
 
 	fmt.Printf("任务包含 %d 个关键词: %v\n", len(keywords), keywords)
 
 	// 用于存储所有关键词的结果
 	allResults := []Product{}
-	// 记录任务整体执行状态
-	overallResult := "done"
+	// 待保存结果的关键词任务
+	pending := []*Task{}
+	// 每个关键词的执行情况
+	subtasks := make([]db.Subtask, 0, len(keywords))
+
+	// 整个任务的代理流量和重试预算
+	taskTraffic := NewTrafficStats()
+	retryBudget := NewRetryBudget()
 
 	// 使用WaitGroup等待所有协程完成
 	var wg sync.WaitGroup
@@ -848,10 +815,14 @@ func main() {
 			defer wg.Done()
 
 			fmt.Printf("开始处理关键词: %s\n", kw)
+			startedAt := time.Now()
+			if err := postgresDB.StartSubtask(taskID, kw, startedAt); err != nil {
+				logs.Warn(err)
+			}
 
 			// 创建单个关键词的任务
 			task := Task{
-				TaskID:   *taskID,
+				TaskID:   taskID,
 				TaskType: taskInfo.TaskType,
 				Keyword:  kw, // 使用当前关键词
 				Category: taskInfo.Category,
@@ -859,27 +830,37 @@ func main() {
 				MinPage:  taskInfo.MinPage,
 				Code:     taskInfo.CountryCode,
 				ZipCode:  taskInfo.Zipcode,
+				Proxy:    proxyKind,
+
+				RetryBudget: retryBudget,
 			}
 
 			// 处理任务
-			result := ProcessingTask(&task)
+			result := ProcessingTask(ctx, &task)
 			fmt.Printf("关键词 '%s' 处理结果: %s\n", kw, result)
+			taskTraffic.Merge(task.Traffic)
+
+			subtask := newSubtask(&task, result, startedAt)
+			if err := postgresDB.FinishSubtask(subtask); err != nil {
+				logs.Warn(err)
+			}
 
 			// 使用互斥锁保护共享资源的访问
 			mu.Lock()
 			defer mu.Unlock()
 
-			// 如果任务成功完成，将结果添加到总结果中
-			if result == "done" && task.TaskType == "search_products" {
+			subtasks = append(subtasks, subtask)
+			// 完成、部分完成和抓取到结果后被中断的关键词保存结果
+			if subtask.Status != SubtaskFailed && task.TaskType == "search_products" {
+				if result != "interrupted" || len(task.Result) > 0 {
+					pending = append(pending, &task)
+				}
 				if len(task.Result) > 0 {
 					fmt.Printf("关键词 '%s' 找到 %d 个产品\n", kw, len(task.Result))
 					allResults = append(allResults, task.Result...)
 				} else {
 					fmt.Printf("警告: 关键词 '%s' 处理成功但没有找到产品\n", kw)
 				}
-			} else if result != "done" {
-				// 如果有任何一个关键词处理失败，记录整体状态为失败
-				overallResult = "failed"
 			}
 		}(keyword) // 立即传入当前关键词值
 	}
@@ -887,56 +868,81 @@ func main() {
 	// 等待所有协程完成
 	wg.Wait()
 
-	// 根据任务执行结果更新任务状态
-	fmt.Println(overallResult)
-	if overallResult == "done" {
-		fmt.Println(taskInfo)
-		fmt.Println(taskInfo.TaskType)
-		// 如果所有关键词任务都成功完成
-		if taskInfo.TaskType == "search_products" {
-			// 统计不重复的ASIN值总数
-			asinMap := make(map[string]bool)
-			fmt.Printf("总共收集到 %d 个产品结果\n", len(allResults))
-
-			if len(allResults) > 0 {
-				for _, product := range allResults {
-					if product.ASIN != "" {
-						asinMap[product.ASIN] = true
-					}
-				}
-			}
-			asinCount := len(asinMap)
+	// 记录任务使用的代理流量
+	if err := saveTaskTraffic(postgresDB, taskID, taskTraffic); err != nil {
+		logs.Err("保存任务代理流量失败:", err)
+	}
 
-			fmt.Printf("所有关键词处理完成，共找到 %d 个不重复的ASIN\n", asinCount)
+	// 全部关键词结束后统一保存结果。租约失效或worker退出后任务会被重新执行，不保存部分结果，避免重新执行后结果重复
+	cause := context.Cause(ctx)
+	state := requeueFrom(ctx)
+	requeue := state != nil && errors.Is(cause, errShutdown)
+	if errors.Is(cause, errLeaseLost) || requeue {
+		fmt.Printf("任务将被重新执行，不保存%d个关键词的结果\n", len(pending))
+	} else {
+		for _, task := range pending {
+			saveTaskResults(ctx, task)
+		}
+		if state != nil {
+			state.saved.Store(true)
+		}
+	}
 
-			// 更新任务状态为已完成
-			updateErr := postgresDB.UpdateTaskSuccess(*taskID, asinCount)
-			if updateErr != nil {
-				fmt.Printf("更新任务状态失败: %v\n", updateErr)
-			} else {
-				fmt.Printf("任务执行成功，共找到 %d 个不重复的ASIN\n", asinCount)
-			}
+	// 根据各关键词的执行情况更新任务状态
+	summary := summarizeSubtasks(subtasks)
+	asinCount := countASINs(allResults)
+	fmt.Println(summary)
+	if errors.Is(context.Cause(ctx), errLeaseLost) {
+		// 租约已被回收，任务状态由重新领取任务的worker更新
+		fmt.Println("任务租约已失效，不更新任务状态")
+		return "interrupted"
+	} else if requeue {
+		// 任务状态由worker放回队列时更新
+		fmt.Printf("任务被中断(%v)，由worker放回队列重新执行\n", cause)
+		return "interrupted"
+	} else if ctx.Err() != nil {
+		// 任务被中断，已抓取的结果已经保存
+		reason := fmt.Sprintf("任务被中断(%v)，已保存%d个产品，%s", context.Cause(ctx), len(allResults), summary)
+		if updateErr := postgresDB.UpdateTaskInterrupted(taskID, reason); updateErr != nil {
+			fmt.Printf("更新任务状态失败: %v\n", updateErr)
 		} else {
-			// 其他类型的任务成功完成
-			updateErr := postgresDB.UpdateTaskSuccess(*taskID, 0)
-			if updateErr != nil {
-				fmt.Printf("更新任务状态失败: %v\n", updateErr)
-			} else {
-				fmt.Println("任务执行成功")
-			}
+			fmt.Println(reason)
 		}
-	} else {
-		// 如果任务执行失败
-		errMsg := fmt.Sprintf("任务执行失败，有 %d 个关键词处理失败", len(keywords)-len(allResults))
+		return "interrupted"
+	} else if summary.Done == summary.Total() {
+		// 所有关键词任务都成功完成，搜索任务统计不重复的ASIN值总数
+		fmt.Printf("所有关键词处理完成，共找到 %d 个产品、%d 个不重复的ASIN\n", len(allResults), asinCount)
 
-		updateErr := postgresDB.UpdateTaskFailed(*taskID, errMsg)
+		// 更新任务状态为已完成
+		updateErr := postgresDB.UpdateTaskSuccess(taskID, asinCount)
 		if updateErr != nil {
 			fmt.Printf("更新任务状态失败: %v\n", updateErr)
 		} else {
-			fmt.Println(errMsg)
+			fmt.Println("任务执行成功")
 		}
+		return "done"
+	} else if summary.Done+summary.Partial > 0 {
+		// 部分关键词失败或有页面抓取失败，已抓取的结果已经保存
+		updateErr := postgresDB.UpdateTaskPartial(taskID, asinCount, summary.String())
+		if updateErr != nil {
+			fmt.Printf("更新任务状态失败: %v\n", updateErr)
+		} else {
+			fmt.Printf("任务部分完成，共找到 %d 个不重复的ASIN\n", asinCount)
+		}
+		return "partial"
+	}
+
+	// 所有关键词都处理失败
+	errMsg := "任务执行失败，" + summary.String()
+	updateErr := postgresDB.UpdateTaskFailed(taskID, errMsg)
+	if updateErr != nil {
+		fmt.Printf("更新任务状态失败: %v\n", updateErr)
+	} else {
+		fmt.Println(errMsg)
 	}
+	return "failed"
 }
+
 func main1() bool {
 	err := proxy.UpdateClashConfig()
 	if err != nil {
@@ -960,10 +966,7 @@ func main1() bool {
 
 // GetAmazonDomain 根据code获取对应的Amazon域名
 func GetAmazonDomain(code string) string {
-	if domain, ok := amazonDomains[code]; ok {
-		return domain
-	}
-	return "amazon.com" // 默认返回美国站点
+	return parser.Domain(code)
 }
 
 // GetAmazonZipCode 根据国家代码获取对应的默认邮编
@@ -974,46 +977,6 @@ func GetAmazonZipCode(countryCode string) string {
 	return "10001" // 默认返回美国纽约邮编
 }
 
-// SetAmazonZipCode 设置亚马逊的邮编
-func SetAmazonZipCode(client *resty.Client, amazonDomain string, zipCode string) error {
-	if zipCode == "" {
-		return nil // 如果没有设置邮编，直接返回
-	}
-
-	// 构建地址更改URL
-	addressChangeURL := fmt.Sprintf("https://www.%s/portal-migration/hz/glow/address-change?actionSource=glow", amazonDomain)
-	fmt.Println(addressChangeURL)
-	// 构建JSON数据
-	jsonData := map[string]string{
-		"locationType": "LOCATION_INPUT",
-		"zipCode":      zipCode,
-		"storeContext": "generic",
-		"deviceType":   "web",
-		"pageType":     "Gateway",
-		"actionSource": "glow",
-	}
-
-	// 发送POST请求设置邮编
-	resp, err := client.R().
-		SetBody(jsonData).
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "text/html,*/*").
-		SetHeader("X-Requested-With", "XMLHttpRequest").
-		//SetHeader("anti-csrftoken-a2z", "hNFyXCk7RAja5gwyYyQZoKnVflZdKyaqLIsTwXNvW/kQAAAAAGhX2LUAAAAB").
-		SetHeader("Cookie", "session-id=145-6834662-5711967; session-id-time=2082787201l; i18n-prefs=USD; lc-main=en_US; skin=noskin; ubid-main=133-6157528-6983024; session-token=2kGZ5xtLQxTAZuqJqHddHi3XuJlWQHTwk+Fa0G+bAfomBGXUlo/Dj015/w2TTawlG917fZK4M0vAKZBNeg3l7bTlljlqwFt1+tIrHXmIRxgTWGoOA5rPufarnFsRIiy6yD8CxKViwk8YVCjWb/IGmkbR4vsVMzW/4KbJ+X9qVwKW4eYpBOubKq7c14GiFMmV8/cxU/JmyDvXfX2jk9HsAS6yjYzJuAa7JTyZCjVUjPst4VQpUZqiJ+6yjU6GEspBg2LxQa06vVpwATosBiOKS1gcBQdZuasHkGwhzSTMnWwsBcU8kbkZ3WLrbocmaRdtEmoxiMaGaGOJAFaLTSk3qSpLXEl52lwG; csm-hit=tb:MS7P5C4KZ0YXJJHSYM2G+s-2X1MXHSSYZD8D8E43SZ6|1750587573591&t:1750587573591&adb:adblk_no; rxc=AK0kDNBWRqooeGAg6Fo").
-		Post(addressChangeURL)
-
-	if err != nil {
-		return fmt.Errorf("设置邮编失败: %v", err)
-	}
-
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("设置邮编请求返回非200状态码: %d", resp.StatusCode())
-	}
-	fmt.Println(resp.StatusCode())
-	return nil
-}
-
 // MongoProduct 表示MongoDB中的产品格式
 type MongoProduct struct {
 	Position     MongoPosition `json:"position" bson:"position"`
@@ -1029,6 +992,9 @@ type MongoProduct struct {
 	BestSeller   bool          `json:"best_seller" bson:"best_seller"`
 	Thumbnail    string        `json:"thumbnail" bson:"thumbnail"`
 	TaskID       string        `json:"task_id" bson:"task_id"`
+
+	Badges          []parser.Badge `json:"badges" bson:"badges"`
+	BoughtPastMonth int            `json:"bought_past_month" bson:"bought_past_month"`
 }
 
 // MongoPosition 表示MongoDB中的位置信息
@@ -1052,50 +1018,14 @@ type MongoReviews struct {
 }
 
 // SaveResultsToMongoDB 将结果保存到MongoDB
-func SaveResultsToMongoDB(products []Product, taskID string) error {
-	// 创建数据库连接
-	postgresDB, err := db.NewPostgresDB()
-	if err != nil {
-		return fmt.Errorf("创建数据库连接失败: %v", err)
-	}
-	defer postgresDB.Close()
-
-	// 从数据库获取MongoDB连接字符串
-	mongoURL, err := postgresDB.GetMongoConfig()
-	if err != nil {
-		return fmt.Errorf("获取MongoDB连接字符串失败: %v", err)
-	}
-	fmt.Println("<UNK>MongoDB<UNK>:", mongoURL)
-	// 创建MongoDB客户端
-	clientOptions := options.Client().ApplyURI(mongoURL)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func SaveResultsToMongoDB(ctx context.Context, products []Product, taskID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-
-	client, err := mongo.Connect(ctx, clientOptions)
-	if err != nil {
-		return fmt.Errorf("连接MongoDB失败: %v", err)
-	}
-	defer func() {
-		if err := client.Disconnect(ctx); err != nil {
-			logs.Err("断开MongoDB连接失败: %v", err)
-		}
-	}()
-
-	// 检查连接
-	err = client.Ping(ctx, nil)
+	collection, disconnect, err := openMongoCollection(ctx, taskID)
 	if err != nil {
-		return fmt.Errorf("MongoDB连接测试失败: %v", err)
+		return err
 	}
-
-	// 获取数据库和集合
-	// 从连接字符串中提取数据库名称
-	databaseName := extractDatabaseName(mongoURL)
-	if databaseName == "" {
-		databaseName = "amazon_scraper" // 默认数据库名
-	}
-
-	database := client.Database(databaseName)
-	collection := database.Collection(taskID)
+	defer disconnect()
 
 	// 转换产品格式并插入数据库
 	var mongoProducts []interface{}
@@ -1131,6 +1061,9 @@ func SaveResultsToMongoDB(products []Product, taskID string) error {
 			AmazonChoice: product.AmazonChoice,
 			BestSeller:   product.BestSeller,
 			Thumbnail:    product.Thumbnail,
+
+			Badges:          product.Badges,
+			BoughtPastMonth: product.BoughtPastMonth,
 		}
 
 		mongoProducts = append(mongoProducts, mongoProduct)
@@ -1148,6 +1081,66 @@ func SaveResultsToMongoDB(products []Product, taskID string) error {
 	return nil
 }
 
+// openMongoCollection 连接MongoDB并返回任务结果的集合，集合名称为任务ID，使用完后调用disconnect断开连接
+func openMongoCollection(ctx context.Context, taskID string) (*mongo.Collection, func(), error) {
+	client, mongoURL, disconnect, err := connectMongo(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 获取数据库和集合
+	// 从连接字符串中提取数据库名称
+	databaseName := extractDatabaseName(mongoURL)
+	if databaseName == "" {
+		databaseName = "amazon_scraper" // 默认数据库名
+	}
+
+	database := client.Database(databaseName)
+	return database.Collection(taskID), disconnect, nil
+}
+
+// MongoResultIDs 返回任务在MongoDB中现有结果的_id，重新解析写入新结果后按这些_id删除旧结果
+func MongoResultIDs(ctx context.Context, taskID string) ([]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	collection, disconnect, err := openMongoCollection(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer disconnect()
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("查询MongoDB中的结果失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+	ids := make([]interface{}, 0)
+	for cursor.Next(ctx) {
+		ids = append(ids, cursor.Current.Lookup("_id"))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("查询MongoDB中的结果失败: %v", err)
+	}
+	return ids, nil
+}
+
+// DeleteResultsFromMongoDB 删除任务在MongoDB中符合filter的结果
+func DeleteResultsFromMongoDB(ctx context.Context, taskID string, filter bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	collection, disconnect, err := openMongoCollection(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	// 每个任务的结果保存在以任务ID命名的集合中
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("删除MongoDB中的结果失败: %v", err)
+	}
+	logs.Info("已删除MongoDB集合%s中的%d条产品数据", taskID, result.DeletedCount)
+	return nil
+}
+
 // extractDatabaseName 从MongoDB连接字符串中提取数据库名称
 func extractDatabaseName(mongoURL string) string {
 	// 简单解析MongoDB连接字符串，提取数据库名
@@ -1180,71 +1173,19 @@ type RedisResult struct {
 	Keyword       string      `json:"keyword"`
 	TotalProducts interface{} `json:"total_products"`
 	Result        []Product   `json:"result"`
-}
-
-func SaveResultsToRedis(task *Task) error {
-	// 创建数据库连接
-	postgresDB, err := db.NewPostgresDB()
-	if err != nil {
-		return fmt.Errorf("创建数据库连接失败: %v", err)
-	}
-	defer postgresDB.Close()
 
-	// 从数据库获取Redis连接字符串
-	redisURL, err := postgresDB.GetRedisConfig()
-	if err != nil {
-		return fmt.Errorf("获取Redis连接字符串失败: %v", err)
-	}
+	CorrectedQuery string         `json:"corrected_query"`
+	Pages          []PageResult   `json:"pages"`
+	Traffic        TrafficSummary `json:"traffic"`
+}
 
+func SaveResultsToRedis(ctx context.Context, task *Task) error {
 	// 创建Redis客户端
-	opts, err := redis.ParseURL(redisURL)
+	client, release, err := openRedisClient()
 	if err != nil {
-		return fmt.Errorf("解析Redis连接字符串失败: %v", err)
+		return err
 	}
-
-	// 确保认证信息正确设置
-	// 如果连接字符串中包含用户名和密码，但ParseURL没有正确解析，手动设置
-	if opts.Password == "" && strings.Contains(redisURL, "@") {
-		fmt.Printf("原始Redis连接字符串: %s\n", redisURL)
-		fmt.Printf("ParseURL后的选项: Username=%s, Password=%s\n", opts.Username, opts.Password)
-
-		// 尝试从URL中提取认证信息
-		parts := strings.Split(redisURL, "@")
-		if len(parts) >= 2 {
-			protocolParts := strings.Split(parts[0], "://")
-			if len(protocolParts) >= 2 {
-				auth := protocolParts[1]
-				if strings.Contains(auth, ":") {
-					// 格式: username:password
-					credentials := strings.Split(auth, ":")
-					if len(credentials) == 2 {
-						opts.Username = credentials[0]
-						opts.Password = credentials[1]
-					}
-				} else {
-					// 格式: redis://username@host:port/db
-					// 在这种情况下，username就是密码
-					opts.Password = auth
-					fmt.Printf("设置密码为: %s\n", auth)
-				}
-			}
-		}
-	}
-
-	client := redis.NewClient(opts)
-	ctx := context.Background()
-
-	// 检查连接
-	_, err = client.Ping(ctx).Result()
-	if err != nil {
-		return fmt.Errorf("Redis连接测试失败: %v", err)
-	}
-	defer func(client *redis.Client) {
-		errClose := client.Close()
-		if errClose != nil {
-
-		}
-	}(client)
+	defer release()
 
 	// 获取Redis队列名称
 	queueName := os.Getenv("REDIS_QUEUE")
@@ -1264,7 +1205,7 @@ func SaveResultsToRedis(task *Task) error {
 
 	// 构建task_key和queue_key
 	taskKey := fmt.Sprintf("ads_assembler:amz_scraper_task_%s", task.TaskID)
-	queueKey := fmt.Sprintf("amazon:scraper_execute_tasks:%s", task.Code)
+	queueKey := taskQueueKey(task.Code)
 
 	// 创建Redis结果对象
 	redisResult := RedisResult{
@@ -1287,6 +1228,10 @@ func SaveResultsToRedis(task *Task) error {
 		Keyword:       task.Keyword,
 		TotalProducts: len(task.Result),
 		Result:        task.Result,
+
+		CorrectedQuery: task.CorrectedQuery,
+		Pages:          task.Pages,
+		Traffic:        task.Traffic.Summary(),
 	}
 
 	// 转换为JSON
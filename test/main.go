@@ -240,23 +240,23 @@ func main() {
 	clash := proxy.New("clash.yaml")
 	clash.Start()
 	// 创建代理URL
-	proxyURL, err := url.Parse("http://127.0.0.1:7890")
+	proxyURL, err := url.Parse(clash.ProxyURL())
 	if err != nil {
 		return
 	} else {
 		fmt.Println(proxyURL.String())
 	}
 	// 检查端口是否可用
-	conn, err := net.DialTimeout("tcp", "127.0.0.1:7890", time.Second*3)
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, time.Second*3)
 	if err != nil {
-		fmt.Println("代理端口7890连接失败:", err)
+		fmt.Println("代理端口连接失败:", err)
 		return
 	}
 	conn.Close()
-	fmt.Println("代理端口7890连接成功")
+	fmt.Println("代理端口连接成功")
 	// 创建resty客户端并设置代理
 	client := resty.New()
-	client.SetProxy("http://127.0.0.1:7890")
+	client.SetProxy(proxyURL.String())
 	client.SetTimeout(30 * time.Second)
 
 	// 显示配置信息
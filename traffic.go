@@ -0,0 +1,162 @@
+package main
+
+import (
+	"awesomeProject/db"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// TrafficCounter 代理流量计数
+type TrafficCounter struct {
+	// Requests 请求次数，包含失败的请求
+	Requests int64 `json:"requests"`
+	// Bytes 经过代理收发的字节数，按连接统计，包含请求、压缩后的响应和TLS开销
+	Bytes int64 `json:"bytes"`
+	// Blocked 被封禁(503、验证码、连接重置)的响应数
+	Blocked int64 `json:"blocked"`
+	// Retries 同一URL的重试次数
+	Retries int64 `json:"retries"`
+}
+
+func (c *TrafficCounter) add(other TrafficCounter) {
+	c.Requests += other.Requests
+	c.Bytes += other.Bytes
+	c.Blocked += other.Blocked
+	c.Retries += other.Retries
+}
+
+// TrafficSummary 任务的代理流量汇总，Cost 按PROXY_COST_PER_GB估算的费用
+type TrafficSummary struct {
+	TrafficCounter
+	Cost  float64                   `json:"cost"`
+	Nodes map[string]TrafficCounter `json:"nodes"`
+}
+
+// TrafficStats 按节点统计代理流量，可以被多个请求并发更新
+type TrafficStats struct {
+	mu    sync.Mutex
+	nodes map[string]*TrafficCounter
+}
+
+// NewTrafficStats 创建流量统计
+func NewTrafficStats() *TrafficStats {
+	return &TrafficStats{nodes: make(map[string]*TrafficCounter)}
+}
+
+// Add 累加节点的流量
+func (s *TrafficStats) Add(node string, counter TrafficCounter) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[node]
+	if !ok {
+		n = &TrafficCounter{}
+		s.nodes[node] = n
+	}
+	n.add(counter)
+}
+
+// Merge 将另一份统计累加到当前统计
+func (s *TrafficStats) Merge(other *TrafficStats) {
+	if s == nil || other == nil {
+		return
+	}
+	for node, counter := range other.Summary().Nodes {
+		s.Add(node, counter)
+	}
+}
+
+// Summary 返回当前的流量汇总
+func (s *TrafficStats) Summary() TrafficSummary {
+	summary := TrafficSummary{Nodes: make(map[string]TrafficCounter)}
+	if s == nil {
+		return summary
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for node, counter := range s.nodes {
+		summary.Nodes[node] = *counter
+		summary.add(*counter)
+	}
+	if price, err := strconv.ParseFloat(os.Getenv("PROXY_COST_PER_GB"), 64); err == nil {
+		summary.Cost = float64(summary.Bytes) / (1 << 30) * price
+	}
+	return summary
+}
+
+// MarshalJSON 以汇总形式输出
+func (s *TrafficStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Summary())
+}
+
+// countingConn 统计连接上收发的字节数
+type countingConn struct {
+	net.Conn
+	count func(n int)
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.count(n)
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.count(n)
+	return n, err
+}
+
+// countWireBytes 统计客户端连接上实际收发的字节数，按建立连接时的节点计入流量统计。
+// resty的Size()是解压后的响应体大小，与代理按流量计费的字节数不一致
+func countWireBytes(client *resty.Client, node func() string, traffic *TrafficStats) {
+	transport, err := client.Transport()
+	if err != nil {
+		return
+	}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// 切换节点时会关闭空闲连接，连接上的流量都属于建立连接时的节点
+		name := node()
+		return &countingConn{Conn: conn, count: func(n int) {
+			if n > 0 {
+				traffic.Add(name, TrafficCounter{Bytes: int64(n)})
+			}
+		}}, nil
+	}
+}
+
+// saveTaskTraffic 将任务的代理流量写入keywords_scrapy_task表
+func saveTaskTraffic(postgresDB *db.PostgresDB, taskID string, stats *TrafficStats) error {
+	summary := stats.Summary()
+	nodes, err := json.Marshal(summary.Nodes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("代理流量: %d次请求, %d字节, %d次封禁, %d次重试, 费用%.4f\n",
+		summary.Requests, summary.Bytes, summary.Blocked, summary.Retries, summary.Cost)
+	return postgresDB.UpdateTaskTraffic(taskID, db.TaskTraffic{
+		Requests: summary.Requests,
+		Bytes:    summary.Bytes,
+		Blocked:  summary.Blocked,
+		Retries:  summary.Retries,
+		Cost:     summary.Cost,
+		Nodes:    nodes,
+	})
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"awesomeProject/db"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logs "github.com/danbai225/go-logs"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// worker模式使用的Redis键
+const (
+	// taskQueuePrefix 按国家划分的任务队列: amazon:scraper_execute_tasks:<国家代码>
+	taskQueuePrefix = "amazon:scraper_execute_tasks:"
+	// workerHeartbeatPrefix worker的心跳: amazon:scraper_workers:<workerID>，过期说明worker已崩溃
+	workerHeartbeatPrefix = "amazon:scraper_workers:"
+	// taskDeliveriesKey 记录每条任务消息被取出的次数
+	taskDeliveriesKey = "amazon:scraper_execute_tasks:deliveries"
+)
+
+// taskQueueKey 返回国家对应的任务队列
+func taskQueueKey(code string) string {
+	return taskQueuePrefix + code
+}
+
+// TaskMessage 表示任务队列中的消息，消息也可以直接是任务ID
+type TaskMessage struct {
+	TaskID string `json:"task_id"`
+}
+
+// parseTaskMessage 从队列消息中取出任务ID
+func parseTaskMessage(msg string) string {
+	msg = strings.TrimSpace(msg)
+	if !strings.HasPrefix(msg, "{") {
+		return msg
+	}
+	var m TaskMessage
+	if err := json.Unmarshal([]byte(msg), &m); err != nil {
+		return ""
+	}
+	return m.TaskID
+}
+
+// Worker 从按国家划分的Redis任务队列中取出任务并执行。取出的消息先移到worker自己的处理中列表，
+// 任务结束后确认(删除)；worker崩溃后心跳过期，其他worker把它处理中的消息放回任务队列
+type Worker struct {
+	ID          string
+	Codes       []string
+	Concurrency int
+	// Block 每次阻塞等待队列的时长
+	Block time.Duration
+	// Heartbeat 心跳的有效期，每1/3有效期续期一次并检查崩溃的worker
+	Heartbeat time.Duration
+	// MaxDeliveries 同一消息最多取出的次数，超过后放入死信队列并将任务标记为失败
+	MaxDeliveries int
+
+	client     *redis.Client
+	postgresDB *db.PostgresDB
+	proxyKind  string
+	next       atomic.Uint64
+}
+
+// runWorkerCommand 以worker模式运行，收到SIGINT/SIGTERM后等待执行中的任务保存结果再退出，返回进程退出码。
+// 任务来源为redis时消费Redis任务队列，为postgres时直接从keywords_scrapy_task表领取待执行的任务
+// 用法: awesome worker [--source redis|postgres] [--code US,DE] [--concurrency 2] [--proxy clash]
+func runWorkerCommand(args []string) int {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	source := fs.String("source", os.Getenv("WORKER_SOURCE"), "任务来源: redis(默认)/postgres")
+	codes := fs.String("code", os.Getenv("WORKER_CODES"), "消费的国家代码，逗号分隔，默认全部站点")
+	concurrency := fs.Int("concurrency", envInt("WORKER_CONCURRENCY", 2), "同时执行的任务数")
+	proxyKind := fs.String("proxy", "", "代理类型: clash/static/gateway/direct，默认读取PROXY_PROVIDER")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: awesome worker [参数]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	ctx, cancel := newShutdownContext()
+	defer cancel()
+	defer closeProviders()
+	defer closeSessionPools()
+	defer closeSharedClients()
+
+	postgresDB, err := db.NewPostgresDB()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "创建数据库连接失败: %v\n", err)
+		return 1
+	}
+	defer postgresDB.Close()
+
+	switch *source {
+	case "redis", "":
+		client, err := postgresDB.NewRedisClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer client.Close()
+
+		worker := NewWorker(client, postgresDB, *proxyKind, splitCodes(*codes), *concurrency)
+		// 任务之间复用Redis和MongoDB连接
+		enableSharedClients(client)
+		err = worker.Run(ctx)
+	case "postgres":
+		var leaseCodes []string
+		if *codes != "" {
+			leaseCodes = splitCodes(*codes)
+		}
+		worker := NewLeaseWorker(postgresDB, *proxyKind, leaseCodes, *concurrency)
+		enableSharedClients(nil)
+		err = worker.Run(ctx)
+	default:
+		err = fmt.Errorf("不支持的任务来源: %s", *source)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// splitCodes 解析逗号分隔的国家代码，为空时返回全部站点
+func splitCodes(value string) []string {
+	codes := []string{}
+	for _, code := range strings.Split(value, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		for code := range amazonZipCodes {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+	}
+	return codes
+}
+
+// NewWorker 创建worker，参数来自环境变量WORKER_BLOCK、WORKER_HEARTBEAT和WORKER_MAX_DELIVERIES
+func NewWorker(client *redis.Client, postgresDB *db.PostgresDB, proxyKind string, codes []string, concurrency int) *Worker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Worker{
+		ID:            newWorkerID(),
+		Codes:         codes,
+		Concurrency:   concurrency,
+		Block:         envDuration("WORKER_BLOCK", 2*time.Second),
+		Heartbeat:     envDuration("WORKER_HEARTBEAT", 30*time.Second),
+		MaxDeliveries: envInt("WORKER_MAX_DELIVERIES", 3),
+		client:        client,
+		postgresDB:    postgresDB,
+		proxyKind:     proxyKind,
+	}
+}
+
+// Run 消费任务队列直到ctx取消，取消后不再取新任务，等待执行中的任务结束
+func (w *Worker) Run(ctx context.Context) error {
+	// 心跳在执行中的任务结束前一直续期
+	beatCtx, stopBeat := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopBeat()
+	if err := w.beat(beatCtx); err != nil {
+		return fmt.Errorf("注册worker失败: %v", err)
+	}
+	w.reap(beatCtx)
+	go w.heartbeatLoop(beatCtx)
+
+	logs.Info(fmt.Sprintf("worker %s 开始消费任务队列%v，并发数%d", w.ID, w.Codes, w.Concurrency))
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+
+	// 正常退出时把未确认的消息放回队列，再删除心跳
+	for _, code := range w.Codes {
+		w.requeueAll(beatCtx, w.processingKey(w.ID, code), code)
+	}
+	stopBeat()
+	if err := w.client.Del(context.WithoutCancel(ctx), workerHeartbeatPrefix+w.ID).Err(); err != nil {
+		logs.Warn("删除worker心跳失败:", err)
+	}
+	logs.Info(fmt.Sprintf("worker %s 已退出", w.ID))
+	return nil
+}
+
+// loop 循环取出并执行任务
+func (w *Worker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		code, msg, err := w.dequeue(ctx)
+		if err != nil {
+			logs.Warn("读取任务队列失败:", err)
+			sleepContext(ctx, w.Block)
+			continue
+		}
+		if msg == "" {
+			continue
+		}
+		w.handle(ctx, code, msg)
+	}
+}
+
+// dequeue 从任务队列取出一条消息并移到处理中列表。依次检查各国家的队列，
+// 都为空时阻塞等待其中一个队列，每次从不同的国家开始以免某个国家的任务一直排在后面
+func (w *Worker) dequeue(ctx context.Context) (string, string, error) {
+	start := int(w.next.Add(1)) % len(w.Codes)
+	for i := range w.Codes {
+		code := w.Codes[(start+i)%len(w.Codes)]
+		msg, err := w.client.LMove(ctx, taskQueueKey(code), w.processingKey(w.ID, code), "LEFT", "RIGHT").Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return code, msg, nil
+	}
+	code := w.Codes[start]
+	msg, err := w.client.BLMove(ctx, taskQueueKey(code), w.processingKey(w.ID, code), "LEFT", "RIGHT", w.Block).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", "", nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	return code, msg, nil
+}
+
+// handle 执行一条任务消息，结束后确认消息；任务panic或被退出信号打断且没有保存结果时放回队列重试
+func (w *Worker) handle(ctx context.Context, code string, msg string) {
+	// 任务开始前和确认消息时不受退出信号影响
+	redisCtx := context.WithoutCancel(ctx)
+	processingKey := w.processingKey(w.ID, code)
+	if ctx.Err() != nil {
+		// 取出消息后收到退出信号，放回队列由其他worker执行
+		w.requeue(redisCtx, processingKey, code, msg)
+		return
+	}
+
+	taskID := parseTaskMessage(msg)
+	if taskID == "" {
+		logs.Warn("无法解析任务消息，放入死信队列:", msg)
+		w.deadLetter(redisCtx, processingKey, code, msg)
+		return
+	}
+	deliveries, err := w.client.HIncrBy(redisCtx, taskDeliveriesKey, msg, 1).Result()
+	if err != nil {
+		logs.Warn("记录任务取出次数失败:", err)
+	}
+	if w.MaxDeliveries > 0 && deliveries > int64(w.MaxDeliveries) {
+		logs.Err(fmt.Sprintf("任务%s已被取出%d次，放入死信队列", taskID, deliveries))
+		w.deadLetter(redisCtx, processingKey, code, msg)
+		errMsg := fmt.Sprintf("任务执行%d次都未完成，已放入死信队列", w.MaxDeliveries)
+		if err := w.postgresDB.UpdateTaskFailed(taskID, errMsg); err != nil {
+			logs.Err("更新任务状态失败:", err)
+		}
+		return
+	}
+
+	logs.Info(fmt.Sprintf("开始执行任务%s(%s，第%d次)", taskID, code, deliveries))
+	taskCtx, state := withRequeue(ctx)
+	result, panicked := runTaskSafely(taskCtx, w.postgresDB, taskID, w.proxyKind)
+	switch {
+	case panicked && state.saved.Load():
+		// 结果已经保存，重新执行会重复保存，标记任务失败
+		if err := w.postgresDB.UpdateTaskFailed(taskID, "任务执行过程中发生panic"); err != nil {
+			logs.Err("更新任务状态失败:", err)
+		}
+	case panicked:
+		w.requeue(redisCtx, processingKey, code, msg)
+		return
+	case errors.Is(context.Cause(ctx), errShutdown) && !state.saved.Load():
+		// 没有保存结果，放回队列由其他worker执行，本次不计入取出次数
+		if err := w.client.HIncrBy(redisCtx, taskDeliveriesKey, msg, -1).Err(); err != nil {
+			logs.Warn("记录任务取出次数失败:", err)
+		}
+		w.requeue(redisCtx, processingKey, code, msg)
+		logs.Info(fmt.Sprintf("任务%s被退出信号打断，已放回队列", taskID))
+		return
+	}
+	// 结果已经保存、任务状态已经更新，确认消息
+	if err := w.client.LRem(redisCtx, processingKey, 1, msg).Err(); err != nil {
+		logs.Err(fmt.Sprintf("确认任务%s失败: %v", taskID, err))
+	}
+	if err := w.client.HDel(redisCtx, taskDeliveriesKey, msg).Err(); err != nil {
+		logs.Warn("清除任务取出次数失败:", err)
+	}
+	logs.Info(fmt.Sprintf("任务%s执行结束: %s", taskID, result))
+}
+
+// newWorkerID 生成worker的ID: <主机名>-<进程ID>-<随机数>
+func newWorkerID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), newSessionID()[:8])
+}
+
+// requeueState 记录worker执行的任务是否已经保存了结果。worker在任务被退出信号打断或panic时把任务放回队列重新执行，
+// 重新执行没有按关键词续跑，已经保存结果的任务不能再放回，否则结果会重复
+type requeueState struct {
+	saved atomic.Bool
+}
+
+type requeueStateKey struct{}
+
+// withRequeue 标记任务由worker执行，收到退出信号时runTask不保存部分结果，由worker放回队列
+func withRequeue(ctx context.Context) (context.Context, *requeueState) {
+	state := &requeueState{}
+	return context.WithValue(ctx, requeueStateKey{}, state), state
+}
+
+// requeueFrom 返回withRequeue设置的状态，不是由worker执行的任务返回nil
+func requeueFrom(ctx context.Context) *requeueState {
+	state, _ := ctx.Value(requeueStateKey{}).(*requeueState)
+	return state
+}
+
+// runTaskSafely 在任务时限内执行任务，捕获任务主协程的panic
+func runTaskSafely(ctx context.Context, postgresDB *db.PostgresDB, taskID string, proxyKind string) (result string, panicked bool) {
+	taskCtx, cancel := withTaskTimeout(ctx)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			logs.Err(fmt.Sprintf("任务%s执行过程中发生panic: %v", taskID, r))
+			result, panicked = "failed", true
+		}
+	}()
+	return runTask(taskCtx, postgresDB, taskID, proxyKind), false
+}
+
+// requeue 把消息从处理中列表放回任务队列的队首
+func (w *Worker) requeue(ctx context.Context, processingKey string, code string, msg string) {
+	_, err := w.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, processingKey, 1, msg)
+		pipe.LPush(ctx, taskQueueKey(code), msg)
+		return nil
+	})
+	if err != nil {
+		logs.Err("任务放回队列失败:", err)
+	}
+}
+
+// deadLetter 把无法执行的消息移到死信队列
+func (w *Worker) deadLetter(ctx context.Context, processingKey string, code string, msg string) {
+	_, err := w.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, processingKey, 1, msg)
+		pipe.RPush(ctx, taskQueueKey(code)+":dead", msg)
+		pipe.HDel(ctx, taskDeliveriesKey, msg)
+		return nil
+	})
+	if err != nil {
+		logs.Err("任务放入死信队列失败:", err)
+	}
+}
+
+// processingKey 返回worker在某个国家的处理中列表: amazon:scraper_execute_tasks:<国家代码>:processing:<workerID>
+func (w *Worker) processingKey(workerID string, code string) string {
+	return taskQueueKey(code) + ":processing:" + workerID
+}
+
+// beat 续期心跳
+func (w *Worker) beat(ctx context.Context) error {
+	return w.client.Set(ctx, workerHeartbeatPrefix+w.ID, time.Now().Unix(), w.Heartbeat).Err()
+}
+
+// heartbeatLoop 定期续期心跳并回收崩溃worker的消息
+func (w *Worker) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.Heartbeat / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.beat(ctx); err != nil {
+				logs.Warn("续期worker心跳失败:", err)
+			}
+			w.reap(ctx)
+		}
+	}
+}
+
+// reap 查找心跳已过期的worker，把它们处理中的消息放回任务队列
+func (w *Worker) reap(ctx context.Context) {
+	iter := w.client.Scan(ctx, 0, taskQueuePrefix+"*:processing:*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		queue, workerID, ok := strings.Cut(key, ":processing:")
+		if !ok || workerID == w.ID {
+			continue
+		}
+		alive, err := w.client.Exists(ctx, workerHeartbeatPrefix+workerID).Result()
+		if err != nil {
+			logs.Warn("检查worker心跳失败:", err)
+			continue
+		}
+		if alive == 0 {
+			w.requeueAll(ctx, key, strings.TrimPrefix(queue, taskQueuePrefix))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		logs.Warn("查找崩溃的worker失败:", err)
+	}
+}
+
+// requeueAll 把处理中列表里的全部消息放回任务队列的队首
+func (w *Worker) requeueAll(ctx context.Context, processingKey string, code string) {
+	count := 0
+	for {
+		err := w.client.LMove(ctx, processingKey, taskQueueKey(code), "RIGHT", "LEFT").Err()
+		if errors.Is(err, redis.Nil) {
+			break
+		}
+		if err != nil {
+			logs.Err("任务放回队列失败:", err)
+			break
+		}
+		count++
+	}
+	if count > 0 {
+		logs.Warn(fmt.Sprintf("已将%s中未完成的%d个任务放回队列", processingKey, count))
+	}
+}
+
+// sharedClients worker模式下在任务之间复用的Redis和MongoDB连接
+var sharedClients struct {
+	sync.Mutex
+	enabled   bool
+	redis     *redis.Client
+	ownsRedis bool
+	mongo     *mongo.Client
+	mongoURL  string
+}
+
+// enableSharedClients 开启连接复用，client为worker已经建立的Redis连接，为nil时第一次使用时建立
+func enableSharedClients(client *redis.Client) {
+	sharedClients.Lock()
+	defer sharedClients.Unlock()
+	sharedClients.enabled = true
+	sharedClients.redis = client
+}
+
+// closeSharedClients 断开复用的连接，传入enableSharedClients的Redis连接由创建者关闭
+func closeSharedClients() {
+	sharedClients.Lock()
+	defer sharedClients.Unlock()
+	if sharedClients.mongo != nil {
+		if err := sharedClients.mongo.Disconnect(context.Background()); err != nil {
+			logs.Err("断开MongoDB连接失败: %v", err)
+		}
+		sharedClients.mongo = nil
+	}
+	if sharedClients.ownsRedis {
+		if err := sharedClients.redis.Close(); err != nil {
+			logs.Err("关闭Redis连接失败: %v", err)
+		}
+		sharedClients.ownsRedis = false
+	}
+	sharedClients.enabled = false
+	sharedClients.redis = nil
+}
+
+// openRedisClient 返回保存结果使用的Redis客户端，使用完后调用release。
+// worker模式下复用进程内的连接，否则根据configs表新建连接
+func openRedisClient() (*redis.Client, func(), error) {
+	sharedClients.Lock()
+	defer sharedClients.Unlock()
+	if sharedClients.redis != nil {
+		return sharedClients.redis, func() {}, nil
+	}
+
+	// 创建数据库连接
+	postgresDB, err := db.NewPostgresDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建数据库连接失败: %v", err)
+	}
+	defer postgresDB.Close()
+
+	// 创建Redis客户端
+	client, err := postgresDB.NewRedisClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	if sharedClients.enabled {
+		sharedClients.redis = client
+		sharedClients.ownsRedis = true
+		return client, func() {}, nil
+	}
+	return client, func() {
+		if errClose := client.Close(); errClose != nil {
+			logs.Err("关闭Redis连接失败: %v", errClose)
+		}
+	}, nil
+}
+
+// connectMongo 连接MongoDB并返回客户端和连接字符串，使用完后调用disconnect。
+// worker模式下复用进程内的连接
+func connectMongo(ctx context.Context) (*mongo.Client, string, func(), error) {
+	sharedClients.Lock()
+	defer sharedClients.Unlock()
+	if sharedClients.mongo != nil {
+		return sharedClients.mongo, sharedClients.mongoURL, func() {}, nil
+	}
+
+	// 创建数据库连接
+	postgresDB, err := db.NewPostgresDB()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("创建数据库连接失败: %v", err)
+	}
+	defer postgresDB.Close()
+
+	// 从数据库获取MongoDB连接字符串
+	mongoURL, err := postgresDB.GetMongoConfig()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("获取MongoDB连接字符串失败: %v", err)
+	}
+	// 创建MongoDB客户端
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURL))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("连接MongoDB失败: %v", err)
+	}
+	disconnect := func() {
+		if err := client.Disconnect(context.WithoutCancel(ctx)); err != nil {
+			logs.Err("断开MongoDB连接失败: %v", err)
+		}
+	}
+
+	// 检查连接
+	if err := client.Ping(ctx, nil); err != nil {
+		disconnect()
+		return nil, "", nil, fmt.Errorf("MongoDB连接测试失败: %v", err)
+	}
+
+	if sharedClients.enabled {
+		sharedClients.mongo = client
+		sharedClients.mongoURL = mongoURL
+		return client, mongoURL, func() {}, nil
+	}
+	return client, mongoURL, disconnect, nil
+}